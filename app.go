@@ -1,6 +1,7 @@
 package main
 
 import (
+	errors2 "errors"
 	"fmt"
 	"github.com/cloudogu/confluence-temp-delete-job/cmd"
 	"github.com/op/go-logging"
@@ -29,18 +30,33 @@ func main() {
 
 	app.Commands = []*cli.Command{
 		cmd.DeleteFilesCommand,
+		cmd.RunOnceCommand,
+		cmd.RestoreCommand,
+		cmd.ProfilesCommand,
 	}
 
 	app.Flags = createGlobalFlags()
 	app.Before = configureLogging
+	// exit codes are handled below so that the app does not exit in the middle of tests
+	app.ExitErrHandler = func(*cli.Context, error) {}
 
 	err := app.Run(os.Args)
 	if err != nil {
 		log.Errorf("%+v\n", err)
-		appExiter.exit(1)
+		appExiter.exit(exitCode(err))
 	}
 }
 
+// exitCode returns the exit code that belongs to the given error. Errors without a dedicated exit code lead to 1.
+func exitCode(err error) int {
+	var exitCoder cli.ExitCoder
+	if errors2.As(err, &exitCoder) {
+		return exitCoder.ExitCode()
+	}
+
+	return 1
+}
+
 func createGlobalFlags() []cli.Flag {
 	return []cli.Flag{
 		&cli.StringFlag{
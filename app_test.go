@@ -1,9 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
 	"testing"
 )
 
@@ -26,6 +28,17 @@ func Test_newExiter(t *testing.T) {
 	})
 }
 
+func Test_exitCode(t *testing.T) {
+	t.Run("should return 1 for regular errors", func(t *testing.T) {
+		assert.Equal(t, 1, exitCode(assert.AnError))
+	})
+	t.Run("should return the exit code of wrapped exit coders", func(t *testing.T) {
+		err := fmt.Errorf("outer: %w", cli.Exit("failed", 2))
+
+		assert.Equal(t, 2, exitCode(err))
+	})
+}
+
 type mockExiter struct {
 	mock.Mock
 }
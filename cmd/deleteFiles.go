@@ -1,141 +1,625 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"github.com/cloudogu/confluence-temp-delete-job/confluence"
 	"github.com/cloudogu/confluence-temp-delete-job/deletion"
 	"github.com/op/go-logging"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
 const (
-	flagMaxAgeHoursLong          = "age"
-	flagMaxAgeHoursShort         = "a"
-	flagLoopIntervalMinutesLong  = "interval"
-	flagLoopIntervalMinutesShort = "i"
+	flagMaxAgeLong               = "age"
+	flagMaxAgeShort              = "a"
+	flagStageLong                = "stage"
+	flagLoopIntervalLong         = "interval"
+	flagLoopIntervalShort        = "i"
+	flagMinDirectoryAgeLong      = "min-dir-age"
+	flagDryRunLong               = "dry-run"
+	flagKeepEmptyDirsLong        = "keep-empty-dirs"
+	flagProfileLong              = "profile"
+	flagIncludeLong              = "include"
+	flagExcludeLong              = "exclude"
+	flagMaxSizeLong              = "max-size"
+	flagTimeSourceLong           = "time-source"
+	flagPolicyLong               = "policy"
+	flagSkipOpenFilesLong        = "skip-open-files"
+	flagMaxErrorsLong            = "max-errors"
+	flagWorkersLong              = "workers"
+	flagReportFormatLong         = "report-format"
+	flagReportFileLong           = "report-file"
+	flagAuditLogLong             = "audit-log"
+	flagAuditHashLong            = "audit-hash"
+	flagQuarantineDirLong        = "quarantine-dir"
+	flagQuarantineRetentionLong  = "quarantine-retention"
+	flagArchiveDirLong           = "archive-dir"
+	flagArchiveFormatLong        = "archive-format"
+	flagArchiveKeepLong          = "archive-keep"
+	flagMinFreeLong              = "min-free"
+	flagMinFreeCheckIntervalLong = "min-free-check-interval"
+	flagMetricsListenLong        = "metrics-listen"
 )
 
-const cpuLoadSleepInSec = 2
+// deleteLoopCommandName names the command that detects the temp directory of Confluence if none is given.
+const deleteLoopCommandName = "delete-loop"
 
-var log = logging.MustGetLogger("cmd")
+var (
+	log = logging.MustGetLogger("cmd")
+	// detectTempDirectory is replaced in tests so that they do not depend on the Confluence installation of the host.
+	detectTempDirectory = confluence.DetectTempDirectory
+)
 
 // DeleteFilesCommand provides CLI entry logic for deleting files..
 var DeleteFilesCommand = &cli.Command{
-	Name:  "delete-loop",
+	Name:  deleteLoopCommandName,
 	Usage: "Endless loop that recursively deletes files and directories according the given parameters",
 	Description: "This command recursively walks the given start directory and deletes files older than the given `age`. " +
-		"Directories will only be deleted last and only if there are no files left to be contained. The loop will run " +
-		"eternally until it receives the following signals: SIGHUP, SIGINT (Strg+C), SIGTERM, SIGKILL.",
+		"Directories will only be deleted last and only if there are no files left to be contained. The first deletion " +
+		"run starts immediately. The loop will run eternally until it receives the following signals: SIGHUP, SIGINT " +
+		"(Strg+C), SIGTERM, SIGKILL. SIGUSR1 starts an additional deletion run. Without directory, the temp directory " +
+		"of Confluence is detected from CATALINA_TMPDIR in setenv.sh or the environment, the installation directory, " +
+		"-Djava.io.tmpdir in CATALINA_OPTS, JAVA_OPTS or setenv.sh or the home directory. Several start directories are deleted one after another in " +
+		"each run; a start directory may replace the age with its own, f. e. /var/tmp/exports:2d.",
 	Action:    deleteFiles,
-	ArgsUsage: "directory",
-	Flags: []cli.Flag{
+	ArgsUsage: "[directory[:age]...]",
+	Flags: append(deletionFlags(),
+		&cli.StringFlag{
+			Name: flagLoopIntervalLong,
+			Usage: "Sets the interval to run the deletion routine as duration like 90m, 2d or P1DT12H. Plain integers " +
+				"are counted in minutes. Must be larger than zero.",
+			Value:   "60m",
+			Aliases: []string{flagLoopIntervalShort},
+		},
+		&cli.StringFlag{
+			Name: flagMinFreeLong,
+			Usage: "Sets a low watermark of free disk space like 10% or 5GiB. If the free space of the start " +
+				"directory's filesystem drops below this watermark, an additional deletion run starts immediately. " +
+				"Disabled if empty.",
+		},
+		&cli.StringFlag{
+			Name: flagMinFreeCheckIntervalLong,
+			Usage: "Sets the interval to check the free disk space against the watermark as duration like 30s. Plain " +
+				"integers are counted in seconds. Must be larger than zero.",
+			Value: "30s",
+		},
+		&cli.StringFlag{
+			Name: flagMetricsListenLong,
+			Usage: "Sets the address like :9100 on which the deletion statistics are served for Prometheus under " +
+				"/metrics. Disabled if empty.",
+		},
+	),
+}
+
+// deletionFlags returns the flags that configure a single deletion run. They are shared by all deleting commands.
+func deletionFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name: flagMaxAgeLong,
+			Usage: "Sets the max. age of files and directories that will be deleted as duration like 90m, 2d or " +
+				"P1DT12H. Plain integers are counted in hours. Must be zero or larger.",
+			Value:   "12h",
+			Aliases: []string{flagMaxAgeShort},
+		},
+		&cli.StringSliceFlag{
+			Name: flagStageLong,
+			Usage: "Adds a lifecycle stage as action:age like compress:2h or delete:7d and replaces --age. Each file " +
+				"is handled by the last stage whose age it exceeds: compress gzips it in place, delete deletes it. " +
+				"Can be repeated in ascending order of age.",
+		},
+		&cli.StringFlag{
+			Name: flagMinDirectoryAgeLong,
+			Usage: "Sets how long an empty directory must have stayed unchanged before it will be deleted as duration " +
+				"like 10m. Plain integers are counted in hours. Zero deletes empty directories immediately.",
+			Value: "0",
+		},
+		&cli.BoolFlag{
+			Name:  flagKeepEmptyDirsLong,
+			Usage: "Never deletes empty directories.",
+		},
+		&cli.StringFlag{
+			Name: flagProfileLong,
+			Usage: "Applies the settings of a built-in profile for a known Confluence directory like temp, work, " +
+				"logs, backups or export. Flags that are set explicitly override the profile. See the command profiles.",
+		},
+		&cli.BoolFlag{
+			Name:  flagDryRunLong,
+			Usage: "Only reports files and directories that would be deleted without deleting them.",
+		},
+		&cli.StringSliceFlag{
+			Name: flagIncludeLong,
+			Usage: "Only deletes files and directories matching this glob pattern relative to the start directory. " +
+				"'**' matches any number of directories. Can be repeated.",
+		},
+		&cli.StringSliceFlag{
+			Name: flagExcludeLong,
+			Usage: "Never deletes files and directories matching this glob pattern relative to the start directory. " +
+				"Excluded directories will not be walked. '**' matches any number of directories. Can be repeated.",
+		},
+		&cli.StringFlag{
+			Name: flagMaxSizeLong,
+			Usage: "Sets a size budget like 500MB or 20GiB for all files in the start directory. After the age-based " +
+				"deletion, the oldest files will be deleted until the budget is met. Disabled if empty.",
+		},
+		&cli.StringFlag{
+			Name: flagTimeSourceLong,
+			Usage: "Sets the file timestamp that determines the age of a file: mtime (modification), atime (access), " +
+				"ctime (inode change), btime (creation, falls back to mtime if unsupported) or newest (newest of all).",
+			Value: string(deletion.TimeSourceModification),
+		},
+		&cli.StringFlag{
+			Name: flagPolicyLong,
+			Usage: "Sets a YAML or JSON policy file that assigns their own max. age, patterns and directory " +
+				"deletion behavior to subdirectories of the start directory. The most specific rule wins.",
+		},
+		&cli.BoolFlag{
+			Name: flagSkipOpenFilesLong,
+			Usage: "Never deletes files that are currently held open by any process visible in /proc. Requires " +
+				"permissions to read the file descriptors of the other processes.",
+		},
+		&cli.IntFlag{
+			Name: flagMaxErrorsLong,
+			Usage: "Sets how many files and directories may fail to be visited or deleted before the deletion run is " +
+				"aborted. -1 tolerates any number of failures, 0 aborts at the first failure.",
+			Value: -1,
+		},
 		&cli.IntFlag{
-			Name:    flagMaxAgeHoursLong,
-			Usage:   "Sets the max. age of files and directories in hours that will be deleted. Must be larger than zero.",
-			Value:   12,
-			Aliases: []string{flagMaxAgeHoursShort},
+			Name: flagWorkersLong,
+			Usage: "Sets how many subdirectories are walked in parallel. Higher values speed up the deletion on " +
+				"network filesystems with a high latency. Must be at least 1.",
+			Value: 1,
+		},
+		&cli.StringFlag{
+			Name: flagReportFormatLong,
+			Usage: "Sets the comma-separated formats of the report after each deletion run: text (one-liner) and/or " +
+				"json (one line of JSON with byte-precise sizes and all failed paths).",
+			Value: reportFormatText,
+		},
+		&cli.StringFlag{
+			Name: flagReportFileLong,
+			Usage: "Appends the JSON reports to this file instead of writing them to stdout. Requires the report " +
+				"format json.",
+		},
+		&cli.StringFlag{
+			Name: flagAuditLogLong,
+			Usage: "Appends one line of JSON per deleted or failed path to this file, including its size, " +
+				"modification time and owner. Disabled if empty.",
+		},
+		&cli.BoolFlag{
+			Name: flagAuditHashLong,
+			Usage: "Adds the SHA-256 checksum of each deleted file's content to the audit log. Every file is read " +
+				"completely before its deletion. Requires --" + flagAuditLogLong + ".",
+		},
+		&cli.StringFlag{
+			Name: flagQuarantineDirLong,
+			Usage: "Moves files into this directory instead of deleting them, so that they can be restored with the " +
+				"restore command. Must be on the same filesystem as the start directory. Disabled if empty.",
+		},
+		&cli.StringFlag{
+			Name: flagQuarantineRetentionLong,
+			Usage: "Sets how long quarantined files are kept before they are deleted for good as duration like 7d or " +
+				"P7D. Plain integers are counted in days.",
+			Value: "7d",
+		},
+		&cli.StringFlag{
+			Name: flagArchiveDirLong,
+			Usage: "Packs the selected files of each deletion run into a compressed bundle in this directory before " +
+				"deleting them. Nothing is deleted if the bundle cannot be written. Disabled if empty.",
+		},
+		&cli.StringFlag{
+			Name:  flagArchiveFormatLong,
+			Usage: "Sets the format of the archive bundles: tar.gz or tar.zst.",
+			Value: string(deletion.ArchiveFormatTarGzip),
 		},
 		&cli.IntFlag{
-			Name:    flagLoopIntervalMinutesLong,
-			Usage:   "Sets the interval in minutes to run the deletion routine. Must be larger than zero.",
-			Value:   60,
-			Aliases: []string{flagLoopIntervalMinutesShort},
+			Name: flagArchiveKeepLong,
+			Usage: "Sets how many archive bundles are kept. Older bundles are deleted after a new bundle was written. " +
+				"0 keeps all bundles.",
 		},
-	},
+	}
 }
 
 func deleteFiles(c *cli.Context) error {
-	directory := ""
-	maxAgeInHours := c.Int(flagMaxAgeHoursLong)
-	loopIntervalInMin := c.Int(flagLoopIntervalMinutesLong)
-	loopInterval := minuteToDuration(loopIntervalInMin)
-
-	switch c.Args().Len() {
-	case 1:
-		directory = c.Args().First()
-	case 0:
-		_ = cli.ShowAppHelp(c)
-		return fmt.Errorf("expected directory as argument")
-	default:
-		_ = cli.ShowAppHelp(c)
-		return fmt.Errorf("unexpected argument(s) found: %v", c.Args().Slice()[1:])
+	loopInterval, err := parsePositiveDurationFlag(c, flagLoopIntervalLong, time.Minute)
+	if err != nil {
+		return err
 	}
 
-	args := deletion.Args{Directory: directory, MaxAgeInHours: maxAgeInHours}
+	roots, err := parseDeletionRoots(c)
+	if err != nil {
+		return err
+	}
 
-	loopStopper := registerUnixSignals()
-	defer close(loopStopper)
+	reporter, err := parseReporter(c)
+	if err != nil {
+		return err
+	}
+
+	trigger, err := parseFreeSpaceTrigger(c)
+	if err != nil {
+		return err
+	}
+	var directories []string
+	for _, root := range roots {
+		warnAboutArgs(root)
+		directories = append(directories, root.Directory)
+	}
+
+	ctx, stop := registerUnixSignals()
+	defer stop()
+
+	metricsAddress := c.String(flagMetricsListenLong)
+	if metricsAddress != "" {
+		reporter.metrics = newMetricsRegistry(directories...)
+		err = serveMetrics(ctx, metricsAddress, reporter.metrics)
+		if err != nil {
+			return err
+		}
+	}
+
+	manualRuns, stopManualRuns := registerManualTrigger()
+	defer stopManualRuns()
 
 	fmt.Println("[tempdel] Start delete-loop...")
-	runDeletionLoop(args, loopInterval, loopStopper)
+	if roots[0].DryRun {
+		fmt.Println("[tempdel] Dry-run mode: no files or directories will be deleted.")
+	}
+	runDeletionLoop(ctx, roots, reporter, loopInterval, trigger, manualRuns)
 
 	return nil
 }
 
-func minuteToDuration(min int) time.Duration {
-	return time.Duration(min) * time.Minute
+func parseFreeSpaceTrigger(c *cli.Context) (*freeSpaceTrigger, error) {
+	if c.String(flagMinFreeLong) == "" {
+		return nil, nil
+	}
+
+	watermark, err := deletion.ParseWatermark(c.String(flagMinFreeLong))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse flag --%s", flagMinFreeLong)
+	}
+	checkInterval, err := parsePositiveDurationFlag(c, flagMinFreeCheckIntervalLong, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFreeSpaceTrigger(watermark, checkInterval), nil
+}
+
+// parseDeletionArgs reads the single start directory and the deletion flags from the CLI context.
+func parseDeletionArgs(c *cli.Context) (deletion.Args, error) {
+	roots, err := parseDeletionRoots(c)
+	if err != nil {
+		return deletion.Args{}, err
+	}
+
+	return roots[0], nil
 }
 
-// registerUnixSignals listens to different unix signals (that Docker or a user might cause) and returns a semaphore
-// channel. A value sent through this channel should stop the deletion loop.
-func registerUnixSignals() (loopStopper chan bool) {
-	loopStopper = make(chan bool, 1)
+// parseDeletionRoots reads the start directories and the deletion flags from the CLI context. Only delete-loop accepts
+// several start directories, each with an optional max. age like /opt/atlassian/confluence/temp:12h, and detects the
+// temp directory of Confluence if there is none. Other commands take the argument as it is.
+func parseDeletionRoots(c *cli.Context) ([]deletion.Args, error) {
+	isDeleteLoop := c.Command.Name == deleteLoopCommandName
+	if c.Args().Len() == 0 && !isDeleteLoop {
+		_ = cli.ShowAppHelp(c)
+		return nil, fmt.Errorf("expected directory as argument")
+	}
+	if c.Args().Len() > 1 && !isDeleteLoop {
+		_ = cli.ShowAppHelp(c)
+		return nil, fmt.Errorf("unexpected argument(s) found: %v", c.Args().Slice()[1:])
+	}
+
+	args, err := parseDeletionFlags(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Args().Len() == 0 {
+		detected, err := detectTempDirectory()
+		if err != nil {
+			_ = cli.ShowAppHelp(c)
+			return nil, errors.Wrap(err, "expected directory as argument")
+		}
+		fmt.Printf("[tempdel] Using temp directory %s (found by %s)\n", detected.Path, detected.Source)
+		args.Directory = detected.Path
+		return []deletion.Args{args}, nil
+	}
+
+	if !isDeleteLoop {
+		args.Directory = c.Args().First()
+		return []deletion.Args{args}, nil
+	}
+
+	var roots []deletion.Args
+	for _, argument := range c.Args().Slice() {
+		root, err := parseRoot(argument, args)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range roots {
+			if deletion.IsBelow(root.Directory, other.Directory) || deletion.IsBelow(other.Directory, root.Directory) {
+				return nil, fmt.Errorf("start directories %q and %q must not contain each other", other.Directory,
+					root.Directory)
+			}
+		}
+		roots = append(roots, root)
+	}
+	// --archive-keep counts all bundles in the archive directory, so each start directory would keep fewer bundles
+	if len(roots) > 1 && args.ArchiveDirectory != "" {
+		return nil, fmt.Errorf("flag --%s cannot be used with several start directories", flagArchiveDirLong)
+	}
+
+	return roots, nil
+}
+
+// parseRoot reads a start directory with an optional max. age like /opt/atlassian/confluence/temp:12h. The age
+// replaces --age and --stage for this directory. An existing directory is always taken as it is, even if its name
+// contains a colon. A colon that is followed by a path separator belongs to the directory, f. e. in C:\temp.
+func parseRoot(argument string, args deletion.Args) (deletion.Args, error) {
+	args.Directory = argument
+
+	separator := strings.LastIndex(argument, ":")
+	if separator <= 0 || strings.ContainsAny(argument[separator+1:], `/\`) || isDirectory(argument) {
+		return args, nil
+	}
+
+	maxAge, err := deletion.ParseDuration(argument[separator+1:], time.Hour)
+	if err != nil {
+		return deletion.Args{}, errors.Wrapf(err, "could not parse age of start directory %q", argument)
+	}
+	args.Directory = argument[:separator]
+	args.MaxAge = maxAge
+	args.Stages = nil
+
+	return args, nil
+}
+
+// parseDeletionFlags reads the deletion flags from the CLI context. The start directory is left empty.
+func parseDeletionFlags(c *cli.Context) (deletion.Args, error) {
+	maxAge, err := deletion.ParseDuration(c.String(flagMaxAgeLong), time.Hour)
+	if err != nil {
+		return deletion.Args{}, errors.Wrapf(err, "could not parse flag --%s", flagMaxAgeLong)
+	}
+
+	var stages []deletion.Stage
+	for _, stage := range c.StringSlice(flagStageLong) {
+		parsed, err := deletion.ParseStage(stage)
+		if err != nil {
+			return deletion.Args{}, errors.Wrapf(err, "could not parse flag --%s", flagStageLong)
+		}
+		stages = append(stages, parsed)
+	}
+
+	minDirectoryAge, err := deletion.ParseDuration(c.String(flagMinDirectoryAgeLong), time.Hour)
+	if err != nil {
+		return deletion.Args{}, errors.Wrapf(err, "could not parse flag --%s", flagMinDirectoryAgeLong)
+	}
+
+	timeSource, err := deletion.ParseTimeSource(c.String(flagTimeSourceLong))
+	if err != nil {
+		return deletion.Args{}, errors.Wrapf(err, "could not parse flag --%s", flagTimeSourceLong)
+	}
+
+	var policy *deletion.Policy
+	if c.String(flagPolicyLong) != "" {
+		policy, err = deletion.LoadPolicy(c.String(flagPolicyLong))
+		if err != nil {
+			return deletion.Args{}, errors.Wrapf(err, "could not load flag --%s", flagPolicyLong)
+		}
+	}
+
+	if c.Int(flagWorkersLong) < 1 {
+		return deletion.Args{}, fmt.Errorf("flag --%s must be at least 1", flagWorkersLong)
+	}
+
+	if c.Bool(flagAuditHashLong) && c.String(flagAuditLogLong) == "" {
+		return deletion.Args{}, fmt.Errorf("flag --%s requires --%s", flagAuditHashLong, flagAuditLogLong)
+	}
+
+	quarantineRetention, err := deletion.ParseDuration(c.String(flagQuarantineRetentionLong), 24*time.Hour)
+	if err != nil {
+		return deletion.Args{}, errors.Wrapf(err, "could not parse flag --%s", flagQuarantineRetentionLong)
+	}
+
+	archiveFormat, err := deletion.ParseArchiveFormat(c.String(flagArchiveFormatLong))
+	if err != nil {
+		return deletion.Args{}, errors.Wrapf(err, "could not parse flag --%s", flagArchiveFormatLong)
+	}
+	if c.String(flagArchiveDirLong) != "" && c.String(flagQuarantineDirLong) != "" {
+		return deletion.Args{}, fmt.Errorf("flags --%s and --%s cannot be used together", flagArchiveDirLong,
+			flagQuarantineDirLong)
+	}
+
+	var maxSizeInBytes int64
+	if c.String(flagMaxSizeLong) != "" {
+		maxSizeInBytes, err = deletion.ParseSize(c.String(flagMaxSizeLong))
+		if err != nil {
+			return deletion.Args{}, errors.Wrapf(err, "could not parse flag --%s", flagMaxSizeLong)
+		}
+	}
+
+	args := deletion.Args{
+		MaxAge:               maxAge,
+		Stages:               stages,
+		MinDirectoryAge:      minDirectoryAge,
+		KeepEmptyDirectories: c.Bool(flagKeepEmptyDirsLong),
+		DryRun:               c.Bool(flagDryRunLong),
+		Include:              c.StringSlice(flagIncludeLong),
+		Exclude:              c.StringSlice(flagExcludeLong),
+		MaxSizeInBytes:       maxSizeInBytes,
+		TimeSource:           timeSource,
+		Policy:               policy,
+		SkipOpenFiles:        c.Bool(flagSkipOpenFilesLong),
+		MaxErrors:            c.Int(flagMaxErrorsLong),
+		Workers:              c.Int(flagWorkersLong),
+		AuditLogFile:         c.String(flagAuditLogLong),
+		AuditChecksums:       c.Bool(flagAuditHashLong),
+		QuarantineDirectory:  c.String(flagQuarantineDirLong),
+		QuarantineRetention:  quarantineRetention,
+		ArchiveDirectory:     c.String(flagArchiveDirLong),
+		ArchiveFormat:        archiveFormat,
+		ArchiveKeep:          c.Int(flagArchiveKeepLong),
+	}
+
+	if c.String(flagProfileLong) != "" {
+		profile, err := confluence.LookupProfile(c.String(flagProfileLong))
+		if err != nil {
+			return deletion.Args{}, errors.Wrapf(err, "could not parse flag --%s", flagProfileLong)
+		}
+		applyProfile(c, &args, profile)
+	}
+
+	return args, nil
+}
+
+// warnAboutArgs prints warnings about settings that are valid but might not work as expected.
+func warnAboutArgs(args deletion.Args) {
+	if args.TimeSource != deletion.TimeSourceAccess {
+		return
+	}
+
+	noAtime, err := deletion.MountedWithNoAtime(args.Directory)
+	if err != nil {
+		log.Warningf("[tempdel] Could not check mount options of %s: %v", args.Directory, err)
+		return
+	}
+	if noAtime {
+		log.Warningf("[tempdel] The filesystem of %s is mounted with noatime. Access times are not updated, so "+
+			"files may be deleted although they are still read.", args.Directory)
+	}
+}
+
+// parsePositiveDurationFlag parses a duration flag. Plain integers are counted in the given legacy unit.
+func parsePositiveDurationFlag(c *cli.Context, name string, legacyUnit time.Duration) (time.Duration, error) {
+	duration, err := deletion.ParseDuration(c.String(name), legacyUnit)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not parse flag --%s", name)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("flag --%s must be larger than zero", name)
+	}
+
+	return duration, nil
+}
+
+// registerUnixSignals listens to different unix signals (that Docker or a user might cause) and returns a context
+// that is cancelled as soon as one of them arrives. This stops the deletion loop as well as a running deletion. The
+// returned function stops listening and must be called once the context is no longer needed.
+func registerUnixSignals() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
 	procSignals := make(chan os.Signal, 1)
 
 	signal.Notify(procSignals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	go func() {
-		for range procSignals {
-			loopStopper <- true
-			break
+		select {
+		case <-procSignals:
+			fmt.Println("[tempdel] Caught signal...")
+			cancel()
+		case <-ctx.Done():
 		}
-		fmt.Println("[tempdel] Caught signal...")
-		close(procSignals)
+		signal.Stop(procSignals)
 	}()
 
-	return loopStopper
+	return ctx, cancel
 }
 
-// the interval is here chosen for seconds for reasons of unit test duration
-func runDeletionLoop(args deletion.Args, intervalInSecs time.Duration, loopStopper chan bool) {
-	var err error
-	ticker := time.NewTicker(intervalInSecs)
+// runDeletionLoop runs a deletion of all start directories immediately and afterwards whenever the interval elapses or
+// a manual run is requested. Start directories whose free disk space drops below the watermark are deleted in
+// between. It blocks until the context is cancelled.
+func runDeletionLoop(ctx context.Context, roots []deletion.Args, reporter runReporter, interval time.Duration,
+	trigger *freeSpaceTrigger, manualRuns <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	freeSpaceChecks, stopFreeSpaceChecks := trigger.ticks()
+	defer stopFreeSpaceChecks()
+
+	runDeletions(ctx, roots, reporter)
 
 	for {
 		select {
-		case <-loopStopper:
-			ticker.Stop()
+		case <-ctx.Done():
 			fmt.Println("[tempdel] Exiting tempdel...")
 			return
 		case <-ticker.C:
-			log.Debug("[tempdel] Start deletion run...")
-			err = deleteFilesWithArgs(args)
-			if err != nil {
-				log.Errorf("[tempdel] Deleting files failed with this error: %s", err.Error())
+			runDeletions(ctx, roots, reporter)
+			trigger.resume()
+		case <-manualRuns:
+			fmt.Println("[tempdel] Starting requested deletion run...")
+			runDeletions(ctx, roots, reporter)
+			trigger.resume()
+		case <-freeSpaceChecks:
+			for _, root := range roots {
+				if ctx.Err() == nil && trigger.shouldRun(root.Directory) {
+					results := runRootDeletion(ctx, root, reporter, len(roots) > 1)
+					trigger.afterTriggeredRun(root.Directory, results)
+				}
 			}
-			log.Debug("[tempdel] End deletion run.")
-		default:
-			// reduces CPU load but stretches reaction to unix signals
-			time.Sleep(cpuLoadSleepInSec * time.Second)
 		}
 	}
 }
 
-func deleteFilesWithArgs(args deletion.Args) error {
+// runDeletions runs a deletion of each start directory one after another. Cancelling the context skips the remaining
+// start directories.
+func runDeletions(ctx context.Context, roots []deletion.Args, reporter runReporter) {
+	for _, root := range roots {
+		if ctx.Err() != nil {
+			return
+		}
+		runRootDeletion(ctx, root, reporter, len(roots) > 1)
+	}
+}
+
+// runRootDeletion runs a deletion of a single start directory. If there are several start directories, their
+// statistics are preceded by the start directory.
+func runRootDeletion(ctx context.Context, root deletion.Args, reporter runReporter, announce bool) *deletion.Results {
+	if announce {
+		fmt.Printf("[tempdel] Deletion run in %s\n", root.Directory)
+	}
+
+	return runDeletion(ctx, root, reporter)
+}
+
+// runDeletion executes a single deletion run and logs errors because a loop must not stop on failed runs.
+func runDeletion(ctx context.Context, args deletion.Args, reporter runReporter) *deletion.Results {
+	log.Debug("[tempdel] Start deletion run...")
+	results, err := deleteFilesWithArgs(ctx, args, reporter)
+	if err != nil {
+		log.Errorf("[tempdel] Deleting files failed with this error: %s", err.Error())
+	}
+	log.Debug("[tempdel] End deletion run.")
+
+	return results
+}
+
+// deleteFilesWithArgs executes a single deletion run and publishes its results, even if the run was interrupted or
+// aborted.
+func deleteFilesWithArgs(ctx context.Context, args deletion.Args, reporter runReporter) (*deletion.Results, error) {
 	deleter, err := deletion.New(args)
 	if err != nil {
-		return errors.Wrap(err, "could not create deleter")
+		return nil, errors.Wrap(err, "could not create deleter")
 	}
 
-	results, err := deleter.Execute()
+	results, err := deleter.Execute(ctx)
+	reportErr := reporter.publish(results)
 	if err != nil {
-		return errors.Wrap(err, "an error occurred during deletion")
+		return results, errors.Wrap(err, "an error occurred during deletion")
+	}
+	if reportErr != nil {
+		return results, errors.Wrap(reportErr, "could not publish the results")
 	}
-	results.PrintStats()
 
-	return nil
+	return results, nil
+}
+
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
 }
@@ -2,12 +2,16 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"github.com/cloudogu/confluence-temp-delete-job/confluence"
 	"github.com/cloudogu/confluence-temp-delete-job/deletion"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -17,10 +21,10 @@ func Test_deleteFilesWithArgs(t *testing.T) {
 
 	t.Run("should fail with missing directory parameter", func(t *testing.T) {
 		// when
-		err := deleteFilesWithArgs(deletion.Args{
-			Directory:     "",
-			MaxAgeInHours: 0,
-		})
+		_, err := deleteFilesWithArgs(context.Background(), deletion.Args{
+			Directory: "",
+			MaxAge:    0,
+		}, textReporter)
 
 		// then
 		require.Error(t, err)
@@ -34,52 +38,123 @@ func Test_deleteFilesWithArgs(t *testing.T) {
 		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
 
 		// when
-		err := deleteFilesWithArgs(deletion.Args{
-			Directory:     dir,
-			MaxAgeInHours: 12,
-		})
+		results, err := deleteFilesWithArgs(context.Background(), deletion.Args{
+			Directory: dir,
+			MaxAge:    12 * time.Hour,
+		}, textReporter)
 
 		// then
 		require.NoError(t, err)
+		assert.Equal(t, 0, results.Failed())
 
 		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
-		assert.Equal(t, "[tempdel] deleted: 0 (0 MB), skipped: 0, failed: 0\n", actualOutput)
+		assert.Contains(t, actualOutput, "[tempdel] deleted: 0 (0 MB), skipped: 0, failed: 0\n")
+		assert.Contains(t, actualOutput, "[tempdel] free disk space: before: ")
 	})
 }
 
 func Test_runDeletionLoop(t *testing.T) {
 	realStdout := os.Stdout
+	const statsLine = "[tempdel] deleted: 0 (0 MB), skipped: 0, failed: 0\n"
 
-	t.Run("should succeed", func(t *testing.T) {
+	t.Run("should run immediately and after each interval", func(t *testing.T) {
 		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
 		defer func() { _ = os.RemoveAll(dir) }()
 		defer restoreOriginalStdout(realStdout)
-		stopChan := make(chan bool, 1)
+		ctx, cancel := context.WithCancel(context.Background())
 
 		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
-		intervalInSec := 1 * time.Second
+		interval := 400 * time.Millisecond
 		args := deletion.Args{
-			Directory:     dir,
-			MaxAgeInHours: 12,
+			Directory: dir,
+			MaxAge:    12 * time.Hour,
 		}
 
 		// when
-		go runDeletionLoop(args, intervalInSec, stopChan)
+		loopDone := make(chan struct{})
+		go func() {
+			runDeletionLoop(ctx, []deletion.Args{args}, textReporter, interval, nil, nil)
+			close(loopDone)
+		}()
 
-		// stop when loop ran 1x
-		time.Sleep(intervalInSec + cpuLoadSleepInSec*time.Second + 1*time.Second)
-		stopChan <- true
+		// stop when loop ran 2x
+		time.Sleep(interval + interval/2)
+		cancel()
+		<-loopDone
 
 		// then
 		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
-		assert.Contains(t, actualOutput, "[tempdel] deleted: 0 (0 MB), skipped: 0, failed: 0\n")
+		assert.Equal(t, 2, strings.Count(actualOutput, statsLine))
+		assert.Contains(t, actualOutput, "[tempdel] Exiting tempdel...\n")
+	})
+	t.Run("should run on manual request", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		defer restoreOriginalStdout(realStdout)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+		manualRuns := make(chan struct{}, 1)
+		args := deletion.Args{
+			Directory: dir,
+			MaxAge:    12 * time.Hour,
+		}
+
+		// when
+		loopDone := make(chan struct{})
+		go func() {
+			runDeletionLoop(ctx, []deletion.Args{args}, textReporter, time.Hour, nil, manualRuns)
+			close(loopDone)
+		}()
+		manualRuns <- struct{}{}
+
+		time.Sleep(500 * time.Millisecond)
+		cancel()
+		<-loopDone
+
+		// then
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Equal(t, 2, strings.Count(actualOutput, statsLine))
+		assert.Contains(t, actualOutput, "[tempdel] Starting requested deletion run...\n")
+	})
+	t.Run("should run each start directory", func(t *testing.T) {
+		firstDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(firstDir) }()
+		secondDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(secondDir) }()
+		defer restoreOriginalStdout(realStdout)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+		roots := []deletion.Args{
+			{Directory: firstDir, MaxAge: 12 * time.Hour},
+			{Directory: secondDir, MaxAge: time.Hour},
+		}
+
+		// when
+		loopDone := make(chan struct{})
+		go func() {
+			runDeletionLoop(ctx, roots, textReporter, time.Hour, nil, nil)
+			close(loopDone)
+		}()
+
+		time.Sleep(300 * time.Millisecond)
+		cancel()
+		<-loopDone
+
+		// then
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Equal(t, 2, strings.Count(actualOutput, statsLine))
+		assert.Contains(t, actualOutput, "[tempdel] Deletion run in "+firstDir+"\n")
+		assert.Contains(t, actualOutput, "[tempdel] Deletion run in "+secondDir+"\n")
+		assert.Less(t, strings.Index(actualOutput, firstDir), strings.Index(actualOutput, secondDir))
 	})
 }
 
 func Test_registerUnixSignals(t *testing.T) {
 	realStdout := os.Stdout
 
-	t.Run("should stop listening and send true through the stop channel", func(t *testing.T) {
+	t.Run("should cancel the context on a signal", func(t *testing.T) {
 		defer restoreOriginalStdout(realStdout)
 		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
 
@@ -89,14 +164,15 @@ func Test_registerUnixSignals(t *testing.T) {
 		}
 
 		// when
-		stopChan := registerUnixSignals()
+		ctx, stop := registerUnixSignals()
+		defer stop()
 
 		// then
 		sigErr := thisTestProcess.Signal(os.Interrupt)
 		println("Sending SIGINT")
 		time.Sleep(2 * time.Second)
 		assert.NoError(t, sigErr)
-		assert.True(t, <-stopChan)
+		assert.Equal(t, context.Canceled, ctx.Err())
 		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
 		assert.Contains(t, actualOutput, "[tempdel] Caught signal...\n")
 	})
@@ -131,15 +207,265 @@ func restoreOriginalStdout(stdout *os.File) {
 	os.Stdout = stdout
 }
 
-func Test_minToSec(t *testing.T) {
-	t.Run("should convert 1 minute to a duration of 60 seconds", func(t *testing.T) {
-		actual := minuteToDuration(1)
+func Test_parsePositiveDurationFlag(t *testing.T) {
+	t.Run("should count plain integers in the legacy unit", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--interval", "1")
+
+		actual, err := parsePositiveDurationFlag(c, flagLoopIntervalLong, time.Minute)
 
+		require.NoError(t, err)
 		assert.Equal(t, time.Duration(60)*time.Second, actual)
 	})
-	t.Run("should convert 60 minutes to a duration of 1 hour", func(t *testing.T) {
-		actual := minuteToDuration(60)
+	t.Run("should parse durations", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--interval", "2d")
+
+		actual, err := parsePositiveDurationFlag(c, flagLoopIntervalLong, time.Minute)
+
+		require.NoError(t, err)
+		assert.Equal(t, 48*time.Hour, actual)
+	})
+	t.Run("should use default value", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand)
+
+		actual, err := parsePositiveDurationFlag(c, flagLoopIntervalLong, time.Minute)
+
+		require.NoError(t, err)
+		assert.Equal(t, time.Hour, actual)
+	})
+	t.Run("should fail on zero", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--interval", "0")
+
+		_, err := parsePositiveDurationFlag(c, flagLoopIntervalLong, time.Minute)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "flag --interval must be larger than zero")
+	})
+	t.Run("should fail on invalid duration", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--interval", "soon")
+
+		_, err := parsePositiveDurationFlag(c, flagLoopIntervalLong, time.Minute)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not parse flag --interval")
+	})
+}
+
+func Test_parseDeletionArgs(t *testing.T) {
+	realStdout := os.Stdout
+
+	t.Run("should parse legacy age in hours", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--age", "24", "/tmp")
+
+		actual, err := parseDeletionArgs(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, "/tmp", actual.Directory)
+		assert.Equal(t, 24*time.Hour, actual.MaxAge)
+	})
+	t.Run("should parse age as duration", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--age", "P1DT12H", "/tmp")
+
+		actual, err := parseDeletionArgs(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, 36*time.Hour, actual.MaxAge)
+	})
+	t.Run("should parse min directory age", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--min-dir-age", "10m", "/tmp")
+
+		actual, err := parseDeletionArgs(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, 10*time.Minute, actual.MinDirectoryAge)
+	})
+	t.Run("should fail on zero workers", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--workers", "0", "/tmp")
+
+		_, err := parseDeletionArgs(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "flag --workers must be at least 1")
+	})
+	t.Run("should parse audit log", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--audit-log", "/var/log/audit.jsonl", "--audit-hash", "/tmp")
+
+		actual, err := parseDeletionArgs(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, "/var/log/audit.jsonl", actual.AuditLogFile)
+		assert.True(t, actual.AuditChecksums)
+	})
+	t.Run("should fail on audit hash without audit log", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--audit-hash", "/tmp")
+
+		_, err := parseDeletionArgs(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "flag --audit-hash requires --audit-log")
+	})
+	t.Run("should detect the temp directory without argument", func(t *testing.T) {
+		defer func() { detectTempDirectory = confluence.DetectTempDirectory }()
+		detectTempDirectory = func() (confluence.TempDirectory, error) {
+			return confluence.TempDirectory{Path: "/opt/confluence/temp", Source: "environment variable CATALINA_TMPDIR"}, nil
+		}
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		actual, err := parseDeletionArgs(newTestContext(t, DeleteFilesCommand))
+
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		require.NoError(t, err)
+		assert.Equal(t, "/opt/confluence/temp", actual.Directory)
+		assert.Contains(t, actualOutput,
+			"[tempdel] Using temp directory /opt/confluence/temp (found by environment variable CATALINA_TMPDIR)\n")
+	})
+	t.Run("should fail if the temp directory cannot be detected", func(t *testing.T) {
+		defer func() { detectTempDirectory = confluence.DetectTempDirectory }()
+		detectTempDirectory = func() (confluence.TempDirectory, error) {
+			return confluence.TempDirectory{}, assert.AnError
+		}
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		_, err := parseDeletionArgs(newTestContext(t, DeleteFilesCommand))
+
+		_ = captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "expected directory as argument")
+	})
+	t.Run("should parse stages", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--stage", "compress:2h", "--stage", "delete:7d", "/tmp")
+
+		actual, err := parseDeletionArgs(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, []deletion.Stage{
+			{Action: deletion.StageActionCompress, Age: deletion.Duration(2 * time.Hour)},
+			{Action: deletion.StageActionDelete, Age: deletion.Duration(7 * 24 * time.Hour)},
+		}, actual.Stages)
+	})
+	t.Run("should fail on invalid stage", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--stage", "move:2h", "/tmp")
+
+		_, err := parseDeletionArgs(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not parse flag --stage")
+	})
+	t.Run("should parse archive flags", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--archive-dir", "/archive", "--archive-format", "tar.zst",
+			"--archive-keep", "3", "/tmp")
+
+		actual, err := parseDeletionArgs(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, "/archive", actual.ArchiveDirectory)
+		assert.Equal(t, deletion.ArchiveFormatTarZstd, actual.ArchiveFormat)
+		assert.Equal(t, 3, actual.ArchiveKeep)
+	})
+	t.Run("should fail on archive together with quarantine", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--archive-dir", "/archive", "--quarantine-dir", "/quarantine", "/tmp")
+
+		_, err := parseDeletionArgs(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "flags --archive-dir and --quarantine-dir cannot be used together")
+	})
+}
+
+func Test_parseDeletionRoots(t *testing.T) {
+	t.Run("should parse start directories with their own age", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--age", "12h", "--stage", "delete:3d", "/tmp/a", "/tmp/b:2d",
+			"/tmp/c:36")
+
+		actual, err := parseDeletionRoots(c)
+
+		require.NoError(t, err)
+		require.Len(t, actual, 3)
+		assert.Equal(t, "/tmp/a", actual[0].Directory)
+		assert.Len(t, actual[0].Stages, 1)
+		assert.Equal(t, "/tmp/b", actual[1].Directory)
+		assert.Equal(t, 48*time.Hour, actual[1].MaxAge)
+		assert.Empty(t, actual[1].Stages)
+		assert.Equal(t, "/tmp/c", actual[2].Directory)
+		assert.Equal(t, 36*time.Hour, actual[2].MaxAge)
+	})
+	t.Run("should keep colons that belong to the directory", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, `C:\temp`, `D:\exports:1d`)
+
+		actual, err := parseDeletionRoots(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, `C:\temp`, actual[0].Directory)
+		assert.Equal(t, `D:\exports`, actual[1].Directory)
+		assert.Equal(t, 24*time.Hour, actual[1].MaxAge)
+	})
+	t.Run("should take existing directories with colon as they are", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "x"), 0755))
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "x:12"), 0755))
+		c := newTestContext(t, DeleteFilesCommand, "--age", "2d", filepath.Join(dir, "x:12"))
+
+		actual, err := parseDeletionRoots(c)
+
+		require.NoError(t, err)
+		require.Len(t, actual, 1)
+		assert.Equal(t, filepath.Join(dir, "x:12"), actual[0].Directory)
+		assert.Equal(t, 48*time.Hour, actual[0].MaxAge)
+	})
+	t.Run("should fail on invalid age", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "/tmp/a:soon")
+
+		_, err := parseDeletionRoots(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `could not parse age of start directory "/tmp/a:soon"`)
+	})
+	t.Run("should fail on nested start directories", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "/tmp/a", "/tmp/b", "/tmp/a/exports:1d")
+
+		_, err := parseDeletionRoots(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `start directories "/tmp/a" and "/tmp/a/exports" must not contain each other`)
+	})
+	t.Run("should fail on nested relative and absolute start directories", func(t *testing.T) {
+		workingDirectory, err := os.Getwd()
+		require.NoError(t, err)
+		c := newTestContext(t, DeleteFilesCommand, filepath.Join(workingDirectory, "x"), "x/y")
+
+		_, err = parseDeletionRoots(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must not contain each other")
+	})
+	t.Run("should fail on archive with several start directories", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--archive-dir", "/var/archive", "/tmp/a", "/tmp/b")
+
+		_, err := parseDeletionRoots(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "flag --archive-dir cannot be used with several start directories")
+	})
+	t.Run("should accept only one start directory for run-once", func(t *testing.T) {
+		realStdout := os.Stdout
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+		c := newTestContext(t, RunOnceCommand, "/tmp/a", "/tmp/b")
 
-		assert.Equal(t, time.Duration(1)*time.Hour, actual)
+		_, err := parseDeletionRoots(c)
+
+		_ = captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unexpected argument(s) found: [/tmp/b]")
+	})
+	t.Run("should take the start directory of run-once as it is", func(t *testing.T) {
+		c := newTestContext(t, RunOnceCommand, "--age", "2d", "/tmp/a:12")
+
+		actual, err := parseDeletionRoots(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, "/tmp/a:12", actual[0].Directory)
+		assert.Equal(t, 48*time.Hour, actual[0].MaxAge)
 	})
 }
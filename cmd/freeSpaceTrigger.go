@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"time"
+)
+
+// freeSpaceTrigger requests additional deletion runs as soon as the free disk space of the start directory's
+// filesystem drops below a watermark.
+type freeSpaceTrigger struct {
+	watermark     deletion.Watermark
+	checkInterval time.Duration
+	// paused contains the start directories for which a triggered run could not free enough space. They get no
+	// further triggered runs because otherwise the whole directory tree would be walked on every check without any
+	// chance of success.
+	paused map[string]bool
+}
+
+// newFreeSpaceTrigger creates a trigger for the given watermark. It returns nil if the watermark is disabled.
+func newFreeSpaceTrigger(watermark deletion.Watermark, checkInterval time.Duration) *freeSpaceTrigger {
+	if !watermark.Enabled() {
+		return nil
+	}
+
+	return &freeSpaceTrigger{watermark: watermark, checkInterval: checkInterval, paused: map[string]bool{}}
+}
+
+// ticks returns a channel that fires whenever the free disk space should be checked. A nil trigger returns a nil
+// channel that never fires.
+func (t *freeSpaceTrigger) ticks() (ticks <-chan time.Time, stop func()) {
+	if t == nil {
+		return nil, func() {}
+	}
+
+	ticker := time.NewTicker(t.checkInterval)
+	return ticker.C, ticker.Stop
+}
+
+// shouldRun checks the free disk space of the given directory and returns true if it is below the watermark.
+func (t *freeSpaceTrigger) shouldRun(directory string) bool {
+	if t.paused[directory] {
+		return false
+	}
+
+	space, err := deletion.FreeDiskSpace(directory)
+	if err != nil {
+		log.Warningf("[tempdel] Could not check free disk space: %v", err)
+		return false
+	}
+
+	if !t.watermark.UndercutBy(space) {
+		return false
+	}
+
+	log.Warningf("[tempdel] Free disk space of %d MB (%.1f%%) dropped below watermark %s", space.FreeBytes/1024/1024,
+		space.FreePercent(), t.watermark)
+	return true
+}
+
+// afterTriggeredRun pauses the trigger for the given start directory until the next scheduled run if the given results
+// still undercut the watermark.
+func (t *freeSpaceTrigger) afterTriggeredRun(directory string, results *deletion.Results) {
+	if results == nil {
+		return
+	}
+
+	space, ok := results.DiskSpaceAfter()
+	if ok && t.watermark.UndercutBy(space) {
+		log.Warningf("[tempdel] Free disk space is still below watermark %s after deletion run of %s; pausing the "+
+			"watermark trigger for this directory until the next scheduled run", t.watermark, directory)
+		t.paused[directory] = true
+	}
+}
+
+// resume re-enables the trigger for all start directories.
+func (t *freeSpaceTrigger) resume() {
+	if t != nil {
+		t.paused = map[string]bool{}
+	}
+}
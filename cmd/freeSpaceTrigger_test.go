@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_newFreeSpaceTrigger(t *testing.T) {
+	t.Run("should return nil for disabled watermark", func(t *testing.T) {
+		actual := newFreeSpaceTrigger(deletion.Watermark{}, time.Second)
+
+		assert.Nil(t, actual)
+		ticks, stop := actual.ticks()
+		assert.Nil(t, ticks)
+		stop()
+	})
+	t.Run("should create trigger", func(t *testing.T) {
+		actual := newFreeSpaceTrigger(deletion.Watermark{MinFreePercent: 10}, time.Second)
+
+		require.NotNil(t, actual)
+		assert.Equal(t, time.Second, actual.checkInterval)
+	})
+}
+
+func Test_freeSpaceTrigger_shouldRun(t *testing.T) {
+	t.Run("should run if the watermark is undercut", func(t *testing.T) {
+		sut := newFreeSpaceTrigger(deletion.Watermark{MinFreeBytes: math.MaxUint64}, time.Second)
+
+		assert.True(t, sut.shouldRun(os.TempDir()))
+	})
+	t.Run("should not run if enough space is left", func(t *testing.T) {
+		sut := newFreeSpaceTrigger(deletion.Watermark{MinFreeBytes: 1}, time.Second)
+
+		assert.False(t, sut.shouldRun(os.TempDir()))
+	})
+	t.Run("should not run while paused", func(t *testing.T) {
+		sut := newFreeSpaceTrigger(deletion.Watermark{MinFreeBytes: math.MaxUint64}, time.Second)
+		sut.paused[os.TempDir()] = true
+
+		assert.False(t, sut.shouldRun(os.TempDir()))
+
+		sut.resume()
+		assert.True(t, sut.shouldRun(os.TempDir()))
+	})
+	t.Run("should not run if the disk space cannot be read", func(t *testing.T) {
+		sut := newFreeSpaceTrigger(deletion.Watermark{MinFreeBytes: math.MaxUint64}, time.Second)
+
+		assert.False(t, sut.shouldRun("/this/directory/does/not/exist"))
+	})
+}
+
+func Test_freeSpaceTrigger_afterTriggeredRun(t *testing.T) {
+	t.Run("should pause if the watermark is still undercut", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		results, err := deleteFilesWithArgs(context.Background(), deletion.Args{Directory: dir, MaxAge: 12 * time.Hour}, textReporter)
+		require.NoError(t, err)
+		sut := newFreeSpaceTrigger(deletion.Watermark{MinFreeBytes: math.MaxUint64}, time.Second)
+
+		sut.afterTriggeredRun(dir, results)
+
+		assert.True(t, sut.paused[dir])
+		assert.True(t, sut.shouldRun(os.TempDir()), "other directories keep their trigger")
+	})
+	t.Run("should not pause if enough space was freed", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		results, err := deleteFilesWithArgs(context.Background(), deletion.Args{Directory: dir, MaxAge: 12 * time.Hour}, textReporter)
+		require.NoError(t, err)
+		sut := newFreeSpaceTrigger(deletion.Watermark{MinFreeBytes: 1}, time.Second)
+
+		sut.afterTriggeredRun(dir, results)
+
+		assert.False(t, sut.paused[dir])
+	})
+}
+
+func Test_runDeletionLoop_freeSpaceTrigger(t *testing.T) {
+	realStdout := os.Stdout
+
+	t.Run("should run deletion when the watermark is undercut", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		defer restoreOriginalStdout(realStdout)
+		ctx, cancel := context.WithCancel(context.Background())
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+		trigger := newFreeSpaceTrigger(deletion.Watermark{MinFreeBytes: math.MaxUint64}, 100*time.Millisecond)
+
+		// when
+		loopDone := make(chan struct{})
+		go func() {
+			runDeletionLoop(ctx, []deletion.Args{{Directory: dir, MaxAge: 12 * time.Hour}}, textReporter, time.Hour, trigger, nil)
+			close(loopDone)
+		}()
+
+		time.Sleep(500 * time.Millisecond)
+		cancel()
+		<-loopDone
+
+		// then
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		// one immediate run at startup and at least one triggered run
+		assert.GreaterOrEqual(t, strings.Count(actualOutput, "[tempdel] deleted: 0 (0 MB), skipped: 0, failed: 0\n"), 2)
+	})
+}
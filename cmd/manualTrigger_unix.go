@@ -0,0 +1,39 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// registerManualTrigger returns a channel that receives a value whenever the process receives SIGUSR1, f. e. by
+// `kill -USR1`. The returned function stops listening.
+func registerManualTrigger() (<-chan struct{}, func()) {
+	procSignals := make(chan os.Signal, 1)
+	manualRuns := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	signal.Notify(procSignals, syscall.SIGUSR1)
+
+	go func() {
+		for {
+			select {
+			case <-procSignals:
+				select {
+				case manualRuns <- struct{}{}:
+				default:
+					// a requested run is already pending
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return manualRuns, func() {
+		signal.Stop(procSignals)
+		close(done)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_registerManualTrigger(t *testing.T) {
+	t.Run("should request a run on SIGUSR1", func(t *testing.T) {
+		thisTestProcess, procErr := os.FindProcess(os.Getpid())
+		require.NoError(t, procErr)
+
+		// when
+		manualRuns, stop := registerManualTrigger()
+		defer stop()
+
+		// then
+		require.NoError(t, thisTestProcess.Signal(syscall.SIGUSR1))
+		select {
+		case <-manualRuns:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected a requested run after SIGUSR1")
+		}
+	})
+}
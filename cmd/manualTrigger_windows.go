@@ -0,0 +1,6 @@
+package cmd
+
+// registerManualTrigger returns a channel that never receives a value because Windows does not support SIGUSR1.
+func registerManualTrigger() (<-chan struct{}, func()) {
+	return nil, func() {}
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsShutdownTimeout = 5 * time.Second
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// metricsRegistry accumulates the results of all deletion runs and exposes them in the Prometheus text format. It is
+// safe for concurrent use by the deletion loop and the HTTP server.
+type metricsRegistry struct {
+	mutex       sync.Mutex
+	directories map[string]*directoryMetrics
+}
+
+// directoryMetrics contains the metrics of a single start directory.
+type directoryMetrics struct {
+	runs            int64
+	deletedFiles    int64
+	deletedBytes    int64
+	compressedFiles int64
+	skippedFiles    int64
+	failedFiles     int64
+	// lastRun contains the report of the latest deletion run. It is nil until the first run finished.
+	lastRun *deletion.Report
+	// directorySize contains the size of the remaining files after the latest complete run. Interrupted runs do not
+	// walk the whole directory and therefore keep the previous size.
+	directorySize      int64
+	directorySizeKnown bool
+}
+
+// newMetricsRegistry creates a registry that exposes zero values for the given start directories until their first
+// deletion run finished.
+func newMetricsRegistry(directories ...string) *metricsRegistry {
+	registry := &metricsRegistry{directories: map[string]*directoryMetrics{}}
+	for _, directory := range directories {
+		registry.directories[directory] = &directoryMetrics{}
+	}
+
+	return registry
+}
+
+// observe adds the results of a deletion run to the metrics. A nil registry ignores the results.
+func (m *metricsRegistry) observe(results *deletion.Results) {
+	if m == nil {
+		return
+	}
+
+	report := results.Report()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	metrics, ok := m.directories[report.Directory]
+	if !ok {
+		metrics = &directoryMetrics{}
+		m.directories[report.Directory] = metrics
+	}
+
+	metrics.runs++
+	metrics.deletedFiles += int64(report.Deleted)
+	metrics.deletedBytes += report.DeletedBytes
+	metrics.compressedFiles += int64(report.Compressed)
+	metrics.skippedFiles += int64(report.Skipped)
+	metrics.failedFiles += int64(report.Failed)
+	metrics.lastRun = &report
+	if !report.Interrupted {
+		metrics.directorySize = report.RemainingBytes
+		metrics.directorySizeKnown = true
+	}
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *metricsRegistry) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(writer)
+}
+
+func (m *metricsRegistry) write(writer io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	directories := make([]string, 0, len(m.directories))
+	for directory := range m.directories {
+		directories = append(directories, directory)
+	}
+	sort.Strings(directories)
+
+	writeMetric := func(name, metricType, help string, value func(metrics *directoryMetrics) (float64, bool)) {
+		_, _ = fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+		for _, directory := range directories {
+			if v, ok := value(m.directories[directory]); ok {
+				_, _ = fmt.Fprintf(writer, "%s{directory=\"%s\"} %s\n", name, labelValueEscaper.Replace(directory),
+					strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+	}
+	always := func(value func(metrics *directoryMetrics) int64) func(*directoryMetrics) (float64, bool) {
+		return func(metrics *directoryMetrics) (float64, bool) {
+			return float64(value(metrics)), true
+		}
+	}
+	afterFirstRun := func(value func(report *deletion.Report) float64) func(*directoryMetrics) (float64, bool) {
+		return func(metrics *directoryMetrics) (float64, bool) {
+			if metrics.lastRun == nil {
+				return 0, false
+			}
+			return value(metrics.lastRun), true
+		}
+	}
+
+	writeMetric("tempdel_runs_total", "counter", "Number of finished deletion runs.",
+		always(func(metrics *directoryMetrics) int64 { return metrics.runs }))
+	writeMetric("tempdel_deleted_files_total", "counter", "Number of deleted files and directories.",
+		always(func(metrics *directoryMetrics) int64 { return metrics.deletedFiles }))
+	writeMetric("tempdel_deleted_bytes_total", "counter", "Size of the deleted files in bytes.",
+		always(func(metrics *directoryMetrics) int64 { return metrics.deletedBytes }))
+	writeMetric("tempdel_compressed_files_total", "counter", "Number of files compressed by a lifecycle stage.",
+		always(func(metrics *directoryMetrics) int64 { return metrics.compressedFiles }))
+	writeMetric("tempdel_skipped_files_total", "counter", "Number of files and directories that were not deleted.",
+		always(func(metrics *directoryMetrics) int64 { return metrics.skippedFiles }))
+	writeMetric("tempdel_failed_files_total", "counter",
+		"Number of files and directories that could not be visited or deleted.",
+		always(func(metrics *directoryMetrics) int64 { return metrics.failedFiles }))
+	writeMetric("tempdel_last_run_timestamp_seconds", "gauge", "Unix time when the latest deletion run finished.",
+		afterFirstRun(func(report *deletion.Report) float64 { return float64(report.EndTime.Unix()) }))
+	writeMetric("tempdel_last_run_duration_seconds", "gauge", "Duration of the latest deletion run in seconds.",
+		afterFirstRun(func(report *deletion.Report) float64 { return report.DurationSeconds }))
+	writeMetric("tempdel_last_run_failed_files", "gauge",
+		"Number of files and directories that failed in the latest deletion run.",
+		afterFirstRun(func(report *deletion.Report) float64 { return float64(report.Failed) }))
+	writeMetric("tempdel_directory_size_bytes", "gauge",
+		"Size of the files that remained in the start directory after the latest complete deletion run.",
+		func(metrics *directoryMetrics) (float64, bool) {
+			return float64(metrics.directorySize), metrics.directorySizeKnown
+		})
+}
+
+// serveMetrics serves the metrics under /metrics on the given address until the context is done. Errors while
+// opening the address are returned immediately so that a wrong address stops the command at startup.
+func serveMetrics(ctx context.Context, address string, registry *metricsRegistry) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrapf(err, "could not listen on metrics address %q", address)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("[tempdel] Metrics server stopped: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("[tempdel] Serving metrics on %s/metrics\n", listener.Addr())
+	return nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_metricsRegistry(t *testing.T) {
+	t.Run("should expose zero counters before the first run", func(t *testing.T) {
+		sut := newMetricsRegistry("/tmp")
+		buffer := &bytes.Buffer{}
+
+		// when
+		sut.write(buffer)
+
+		// then
+		assert.Contains(t, buffer.String(), "# TYPE tempdel_runs_total counter\n")
+		assert.Contains(t, buffer.String(), "tempdel_runs_total{directory=\"/tmp\"} 0\n")
+		assert.NotContains(t, buffer.String(), "tempdel_last_run_timestamp_seconds{")
+		assert.NotContains(t, buffer.String(), "tempdel_directory_size_bytes{")
+	})
+	t.Run("should accumulate the results of several runs", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		stayFile := filepath.Join(dir, "stay")
+		require.NoError(t, ioutil.WriteFile(stayFile, make([]byte, 42), 0644))
+		sut := newMetricsRegistry(dir)
+		reporter := runReporter{metrics: sut}
+		args := deletion.Args{Directory: dir, MaxAge: 12 * time.Hour}
+
+		// when
+		_, err := deleteFilesWithArgs(context.Background(), args, reporter)
+		require.NoError(t, err)
+		_, err = deleteFilesWithArgs(context.Background(), args, reporter)
+		require.NoError(t, err)
+
+		// then
+		buffer := &bytes.Buffer{}
+		sut.write(buffer)
+		assert.Contains(t, buffer.String(), "tempdel_runs_total{directory=\""+dir+"\"} 2\n")
+		assert.Contains(t, buffer.String(), "tempdel_skipped_files_total{directory=\""+dir+"\"} 2\n")
+		assert.Contains(t, buffer.String(), "tempdel_directory_size_bytes{directory=\""+dir+"\"} 42\n")
+		assert.Contains(t, buffer.String(), "tempdel_last_run_timestamp_seconds{directory=\""+dir+"\"} ")
+	})
+	t.Run("should escape label values", func(t *testing.T) {
+		sut := newMetricsRegistry("/tmp/\"quoted\"\\")
+		buffer := &bytes.Buffer{}
+
+		// when
+		sut.write(buffer)
+
+		// then
+		assert.Contains(t, buffer.String(), `tempdel_runs_total{directory="/tmp/\"quoted\"\\"} 0`)
+	})
+}
+
+func Test_serveMetrics(t *testing.T) {
+	t.Run("should serve metrics until the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// when
+		err := serveMetrics(ctx, "127.0.0.1:0", newMetricsRegistry("/tmp"))
+
+		// then
+		require.NoError(t, err)
+	})
+	t.Run("should fail on an invalid address", func(t *testing.T) {
+		// when
+		err := serveMetrics(context.Background(), "invalid:address:0", newMetricsRegistry("/tmp"))
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not listen on metrics address")
+	})
+}
+
+func Test_metricsRegistry_ServeHTTP(t *testing.T) {
+	t.Run("should use the Prometheus text format", func(t *testing.T) {
+		sut := newMetricsRegistry("/tmp")
+		recorder := httptest.NewRecorder()
+
+		// when
+		sut.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		// then
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Contains(t, recorder.Body.String(), "tempdel_runs_total{directory=\"/tmp\"} 0\n")
+	})
+}
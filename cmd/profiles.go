@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/cloudogu/confluence-temp-delete-job/confluence"
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"github.com/urfave/cli/v2"
+	"strings"
+	"time"
+)
+
+// ProfilesCommand provides CLI entry logic for listing the built-in profiles of known Confluence directories.
+var ProfilesCommand = &cli.Command{
+	Name:  "profiles",
+	Usage: "Lists the built-in profiles for known Confluence directories",
+	Description: "This command lists the profiles which can be applied to the deleting commands with --profile. Each " +
+		"profile names the usual location of its directory and prints its effective settings as flags. With a profile " +
+		"name as argument, only this profile is printed.",
+	Action:    listProfiles,
+	ArgsUsage: "[profile]",
+}
+
+func listProfiles(c *cli.Context) error {
+	profiles := confluence.Profiles()
+	switch c.Args().Len() {
+	case 0:
+	case 1:
+		profile, err := confluence.LookupProfile(c.Args().First())
+		if err != nil {
+			return err
+		}
+		profiles = []confluence.Profile{profile}
+	default:
+		_ = cli.ShowAppHelp(c)
+		return fmt.Errorf("unexpected argument(s) found: %v", c.Args().Slice()[1:])
+	}
+
+	for _, profile := range profiles {
+		fmt.Printf("%-8s %s\n", profile.Name, profile.Description)
+		fmt.Printf("         location: %s\n", profile.Location)
+		fmt.Printf("         settings: %s\n", strings.Join(profileFlags(profile), " "))
+	}
+
+	return nil
+}
+
+// applyProfile replaces the settings of the given arguments with the settings of the profile unless their flags were
+// set explicitly. Exclude patterns are added to the profile's patterns so that they never unprotect files.
+func applyProfile(c *cli.Context, args *deletion.Args, profile confluence.Profile) {
+	if !c.IsSet(flagMaxAgeLong) && !c.IsSet(flagStageLong) {
+		args.MaxAge = profile.MaxAge
+		args.Stages = profile.Stages
+	}
+	if !c.IsSet(flagMinDirectoryAgeLong) {
+		args.MinDirectoryAge = profile.MinDirectoryAge
+	}
+	if !c.IsSet(flagKeepEmptyDirsLong) {
+		args.KeepEmptyDirectories = profile.KeepEmptyDirectories
+	}
+	if !c.IsSet(flagSkipOpenFilesLong) {
+		args.SkipOpenFiles = profile.SkipOpenFiles
+	}
+	if !c.IsSet(flagIncludeLong) {
+		args.Include = profile.Include
+	}
+	args.Exclude = append(append([]string(nil), profile.Exclude...), args.Exclude...)
+}
+
+// profileFlags returns the settings of the given profile as flags of the deleting commands.
+func profileFlags(profile confluence.Profile) []string {
+	var flags []string
+	if len(profile.Stages) == 0 {
+		flags = append(flags, "--"+flagMaxAgeLong, formatDuration(profile.MaxAge))
+	}
+	for _, stage := range profile.Stages {
+		flags = append(flags, "--"+flagStageLong, fmt.Sprintf("%s:%s", stage.Action, formatDuration(time.Duration(stage.Age))))
+	}
+	if profile.MinDirectoryAge > 0 {
+		flags = append(flags, "--"+flagMinDirectoryAgeLong, formatDuration(profile.MinDirectoryAge))
+	}
+	if profile.KeepEmptyDirectories {
+		flags = append(flags, "--"+flagKeepEmptyDirsLong)
+	}
+	if profile.SkipOpenFiles {
+		flags = append(flags, "--"+flagSkipOpenFilesLong)
+	}
+	for _, pattern := range profile.Include {
+		flags = append(flags, "--"+flagIncludeLong, "'"+pattern+"'")
+	}
+	for _, pattern := range profile.Exclude {
+		flags = append(flags, "--"+flagExcludeLong, "'"+pattern+"'")
+	}
+
+	return flags
+}
+
+// formatDuration writes the given duration in the largest unit that divides it, f. e. 14d, 12h or 10m.
+func formatDuration(duration time.Duration) string {
+	switch {
+	case duration == 0:
+		return "0"
+	case duration%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", duration/(24*time.Hour))
+	case duration%time.Hour == 0:
+		return fmt.Sprintf("%dh", duration/time.Hour)
+	case duration%time.Minute == 0:
+		return fmt.Sprintf("%dm", duration/time.Minute)
+	default:
+		return duration.String()
+	}
+}
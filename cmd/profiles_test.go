@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_listProfiles(t *testing.T) {
+	realStdout := os.Stdout
+
+	t.Run("should print the settings of a profile", func(t *testing.T) {
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		// when
+		err := listProfiles(newTestContext(t, ProfilesCommand, "backups"))
+
+		// then
+		require.NoError(t, err)
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Contains(t, actualOutput, "backups  Daily XML backups")
+		assert.Contains(t, actualOutput, "location: <confluence-home>/backups\n")
+		assert.Contains(t, actualOutput, "settings: --age 14d --keep-empty-dirs --include 'backup-*.zip'\n")
+	})
+	t.Run("should fail on unknown profile", func(t *testing.T) {
+		err := listProfiles(newTestContext(t, ProfilesCommand, "attachments"))
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown profile "attachments"`)
+	})
+}
+
+func Test_applyProfile(t *testing.T) {
+	t.Run("should apply the settings of the profile", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--profile", "logs", "/tmp")
+
+		actual, err := parseDeletionArgs(c)
+
+		require.NoError(t, err)
+		assert.Len(t, actual.Stages, 2)
+		assert.True(t, actual.KeepEmptyDirectories)
+		assert.True(t, actual.SkipOpenFiles)
+		assert.Contains(t, actual.Include, "**/*.log.[0-9]*")
+		assert.Contains(t, actual.Exclude, "**/atlassian-*.log")
+	})
+	t.Run("should keep explicitly set flags", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--profile", "logs", "--age", "2d", "--keep-empty-dirs=false",
+			"--include", "*.log", "--exclude", "audit/**", "/tmp")
+
+		actual, err := parseDeletionArgs(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, 48*time.Hour, actual.MaxAge)
+		assert.Empty(t, actual.Stages)
+		assert.False(t, actual.KeepEmptyDirectories)
+		assert.Equal(t, []string{"*.log"}, actual.Include)
+		assert.Contains(t, actual.Exclude, "**/atlassian-*.log")
+		assert.Contains(t, actual.Exclude, "audit/**")
+	})
+	t.Run("should fail on unknown profile", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--profile", "attachments", "/tmp")
+
+		_, err := parseDeletionArgs(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not parse flag --profile")
+	})
+}
+
+func Test_formatDuration(t *testing.T) {
+	assert.Equal(t, "0", formatDuration(0))
+	assert.Equal(t, "14d", formatDuration(14*24*time.Hour))
+	assert.Equal(t, "12h", formatDuration(12*time.Hour))
+	assert.Equal(t, "10m", formatDuration(10*time.Minute))
+	assert.Equal(t, "1m30s", formatDuration(90*time.Second))
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"os"
+	"strings"
+)
+
+const (
+	reportFormatText = "text"
+	reportFormatJSON = "json"
+)
+
+// runReporter publishes the results of each deletion run in the configured formats.
+type runReporter struct {
+	// text prints the statistics as one-liner.
+	text bool
+	// json writes the statistics as one line of JSON.
+	json bool
+	// file receives the JSON reports instead of stdout. The reports are appended so that the file contains one report
+	// per line.
+	file string
+	// metrics optionally accumulates the results for the metrics endpoint.
+	metrics *metricsRegistry
+}
+
+// parseReporter reads the report flags from the CLI context.
+func parseReporter(c *cli.Context) (runReporter, error) {
+	reporter := runReporter{file: c.String(flagReportFileLong)}
+
+	for _, format := range strings.Split(c.String(flagReportFormatLong), ",") {
+		switch strings.ToLower(strings.TrimSpace(format)) {
+		case reportFormatText:
+			reporter.text = true
+		case reportFormatJSON:
+			reporter.json = true
+		default:
+			return runReporter{}, fmt.Errorf("invalid report format %q in flag --%s: expected %s or %s",
+				format, flagReportFormatLong, reportFormatText, reportFormatJSON)
+		}
+	}
+
+	if reporter.file != "" && !reporter.json {
+		return runReporter{}, fmt.Errorf("flag --%s requires --%s %s", flagReportFileLong, flagReportFormatLong, reportFormatJSON)
+	}
+
+	return reporter, nil
+}
+
+// publish prints or writes the results in all configured formats and adds them to the metrics.
+func (r runReporter) publish(results *deletion.Results) error {
+	r.metrics.observe(results)
+	if r.text {
+		results.PrintStats()
+	}
+	if !r.json {
+		return nil
+	}
+
+	if r.file == "" {
+		return results.WriteJSON(os.Stdout)
+	}
+
+	file, err := os.OpenFile(r.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "could not open report file %q", r.file)
+	}
+	err = results.WriteJSON(file)
+	if err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, "could not write report file %q", r.file)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseReporter(t *testing.T) {
+	t.Run("should print text by default", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "/tmp")
+
+		actual, err := parseReporter(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, textReporter, actual)
+	})
+	t.Run("should parse several formats", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--report-format", "text, JSON", "--report-file", "report.jsonl", "/tmp")
+
+		actual, err := parseReporter(c)
+
+		require.NoError(t, err)
+		assert.Equal(t, runReporter{text: true, json: true, file: "report.jsonl"}, actual)
+	})
+	t.Run("should fail on unknown format", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--report-format", "xml", "/tmp")
+
+		_, err := parseReporter(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid report format "xml"`)
+	})
+	t.Run("should fail on report file without json", func(t *testing.T) {
+		c := newTestContext(t, DeleteFilesCommand, "--report-file", "report.jsonl", "/tmp")
+
+		_, err := parseReporter(c)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "flag --report-file requires --report-format json")
+	})
+}
+
+func Test_runReporter_publish(t *testing.T) {
+	realStdout := os.Stdout
+
+	t.Run("should print json only", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		defer restoreOriginalStdout(realStdout)
+
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		// when
+		_, err := deleteFilesWithArgs(context.Background(), deletion.Args{Directory: dir, MaxAge: 12 * time.Hour},
+			runReporter{json: true})
+
+		// then
+		require.NoError(t, err)
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.NotContains(t, actualOutput, "[tempdel] deleted:")
+		report := deletion.Report{}
+		require.NoError(t, json.Unmarshal([]byte(actualOutput), &report))
+		assert.Equal(t, dir, report.Directory)
+	})
+	t.Run("should append json to report file", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		reportFile := filepath.Join(dir, "report.jsonl")
+		reporter := runReporter{json: true, file: reportFile}
+		args := deletion.Args{Directory: dir, MaxAge: 12 * time.Hour, Exclude: []string{"report.jsonl"}}
+
+		// when
+		_, err := deleteFilesWithArgs(context.Background(), args, reporter)
+		require.NoError(t, err)
+		_, err = deleteFilesWithArgs(context.Background(), args, reporter)
+		require.NoError(t, err)
+
+		// then
+		content, err := ioutil.ReadFile(reportFile)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		require.Len(t, lines, 2)
+		for _, line := range lines {
+			report := deletion.Report{}
+			require.NoError(t, json.Unmarshal([]byte(line), &report))
+			assert.Equal(t, dir, report.Directory)
+		}
+	})
+}
+
+// textReporter only prints the statistics one-liner.
+var textReporter = runReporter{text: true}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"github.com/urfave/cli/v2"
+)
+
+// RestoreCommand provides CLI entry logic for moving quarantined files back to their original location.
+var RestoreCommand = &cli.Command{
+	Name:  "restore",
+	Usage: "Moves quarantined files back to their original location",
+	Description: "This command restores files that a deletion run with --quarantine-dir moved into the quarantine " +
+		"directory. The argument is either the ID of a run, which restores all files of that run, or an original path, " +
+		"which restores the newest quarantined version of that file or of all files below that directory. Existing " +
+		"files are never overwritten. Without argument, the command lists all quarantined runs. The exit code is 2 if " +
+		"some files could not be restored.",
+	Action:    restore,
+	ArgsUsage: "[run-id | path]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     flagQuarantineDirLong,
+			Usage:    "Sets the quarantine directory that was used by the deletion runs.",
+			Required: true,
+		},
+	},
+}
+
+func restore(c *cli.Context) error {
+	quarantineDir := c.String(flagQuarantineDirLong)
+
+	switch c.Args().Len() {
+	case 0:
+		return listQuarantineRuns(quarantineDir)
+	case 1:
+	default:
+		_ = cli.ShowAppHelp(c)
+		return fmt.Errorf("unexpected argument(s) found: %v", c.Args().Slice()[1:])
+	}
+
+	results, err := deletion.Restore(quarantineDir, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("[tempdel] restored: %d, failed: %d\n", results.Restored, len(results.Failures))
+	if len(results.Failures) > 0 {
+		return cli.Exit(fmt.Sprintf("failed to restore %d file(s)", len(results.Failures)), exitCodeFailedPaths)
+	}
+
+	return nil
+}
+
+func listQuarantineRuns(quarantineDir string) error {
+	runs, err := deletion.ListQuarantineRuns(quarantineDir)
+	if err != nil {
+		return err
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("[tempdel] no quarantined runs found")
+		return nil
+	}
+	for _, run := range runs {
+		fmt.Printf("%s  %d file(s)  %d bytes\n", run.ID, run.Files, run.Bytes)
+	}
+
+	return nil
+}
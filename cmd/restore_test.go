@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_restore(t *testing.T) {
+	realStdout := os.Stdout
+
+	t.Run("should restore a quarantined file", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		quarantineDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-quarantine-")
+		defer func() { _ = os.RemoveAll(quarantineDir) }()
+		oldFile := filepath.Join(dir, "export", "old")
+		_ = os.MkdirAll(filepath.Dir(oldFile), 0755)
+		_ = ioutil.WriteFile(oldFile, []byte("old"), 0644)
+		oldTime := time.Now().Add(-20 * time.Hour)
+		_ = os.Chtimes(oldFile, oldTime, oldTime)
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		// when
+		err := runOnce(newTestContext(t, RunOnceCommand, "--quarantine-dir", quarantineDir, dir))
+		require.NoError(t, err)
+		_, err = os.Stat(oldFile)
+		require.True(t, os.IsNotExist(err))
+		err = restore(newTestContext(t, RestoreCommand, "--quarantine-dir", quarantineDir))
+		require.NoError(t, err)
+		err = restore(newTestContext(t, RestoreCommand, "--quarantine-dir", quarantineDir, oldFile))
+
+		// then
+		require.NoError(t, err)
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Contains(t, actualOutput, "[tempdel] quarantined: 2 (0 MB), skipped: 0, failed: 0\n")
+		assert.Regexp(t, `\d{8}T\d{6}\.\d{3}Z  1 file\(s\)  3 bytes\n`, actualOutput)
+		assert.Contains(t, actualOutput, "[tempdel] restored: 1, failed: 0\n")
+		content, err := ioutil.ReadFile(oldFile)
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+	})
+	t.Run("should fail for unknown path", func(t *testing.T) {
+		quarantineDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-quarantine-")
+		defer func() { _ = os.RemoveAll(quarantineDir) }()
+
+		// when
+		err := restore(newTestContext(t, RestoreCommand, "--quarantine-dir", quarantineDir, "/opt/unknown"))
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no quarantined files found")
+	})
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli/v2"
+)
+
+// exitCodeFailedPaths signals that a deletion run finished but could not delete all selected files and directories.
+// Other errors like an aborted run lead to the general exit code 1.
+const exitCodeFailedPaths = 2
+
+// RunOnceCommand provides CLI entry logic for a single deletion run, f. e. for cron jobs.
+var RunOnceCommand = &cli.Command{
+	Name:  "run-once",
+	Usage: "Recursively deletes files and directories according the given parameters exactly once",
+	Description: "This command recursively walks the given start directory and deletes files older than the given `age`. " +
+		"Directories will only be deleted last and only if there are no files left to be contained. In contrast to " +
+		"delete-loop, the command exits after a single deletion run. The exit code is 0 if the run succeeded, " +
+		"1 if the run could not be started or was aborted, and 2 if some files or directories could not be deleted.",
+	Action:    runOnce,
+	ArgsUsage: "directory",
+	Flags:     deletionFlags(),
+}
+
+func runOnce(c *cli.Context) error {
+	args, err := parseDeletionArgs(c)
+	if err != nil {
+		return err
+	}
+	reporter, err := parseReporter(c)
+	if err != nil {
+		return err
+	}
+	warnAboutArgs(args)
+
+	if args.DryRun {
+		fmt.Println("[tempdel] Dry-run mode: no files or directories will be deleted.")
+	}
+	ctx, stop := registerUnixSignals()
+	defer stop()
+
+	results, err := deleteFilesWithArgs(ctx, args, reporter)
+	if err != nil {
+		return err
+	}
+
+	if results.Failed() > 0 {
+		return cli.Exit(fmt.Sprintf("failed to delete %d file(s) or directories", results.Failed()), exitCodeFailedPaths)
+	}
+
+	return nil
+}
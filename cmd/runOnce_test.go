@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_runOnce(t *testing.T) {
+	realStdout := os.Stdout
+
+	t.Run("should fail without directory", func(t *testing.T) {
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		// when
+		err := runOnce(newTestContext(t, RunOnceCommand))
+
+		// then
+		_ = captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "expected directory as argument")
+	})
+	t.Run("should delete old files exactly once", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		oldFile := filepath.Join(dir, "old")
+		_ = ioutil.WriteFile(oldFile, []byte("old"), 0644)
+		oldTime := time.Now().Add(-20 * time.Hour)
+		_ = os.Chtimes(oldFile, oldTime, oldTime)
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		// when
+		err := runOnce(newTestContext(t, RunOnceCommand, dir))
+
+		// then
+		require.NoError(t, err)
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Contains(t, actualOutput, "[tempdel] deleted: 1 (0 MB), skipped: 0, failed: 0\n")
+		_, err = os.Stat(oldFile)
+		assert.True(t, os.IsNotExist(err))
+	})
+	t.Run("should exit with 2 if some files failed", func(t *testing.T) {
+		dir := createFailingFile(t)
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		// when
+		err := runOnce(newTestContext(t, RunOnceCommand, "--stage", "compress:1h", dir))
+
+		// then
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Contains(t, actualOutput, "failed: 1")
+		var exitCoder cli.ExitCoder
+		require.True(t, errors.As(err, &exitCoder))
+		assert.Equal(t, exitCodeFailedPaths, exitCoder.ExitCode())
+	})
+	t.Run("should exit with the general exit code if the run was aborted", func(t *testing.T) {
+		dir := createFailingFile(t)
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		// when
+		err := runOnce(newTestContext(t, RunOnceCommand, "--stage", "compress:1h", "--max-errors", "0", dir))
+
+		// then
+		_ = captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "aborting after 1 failed paths")
+		var exitCoder cli.ExitCoder
+		assert.False(t, errors.As(err, &exitCoder))
+	})
+}
+
+// createFailingFile creates a directory with an old file that cannot be compressed because all names of its
+// compressed file are taken.
+func createFailingFile(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old.log")
+	require.NoError(t, ioutil.WriteFile(oldFile, []byte("old"), 0644))
+	oldTime := time.Now().Add(-20 * time.Hour)
+	require.NoError(t, ioutil.WriteFile(oldFile+".gz", []byte("older"), 0644))
+	require.NoError(t, ioutil.WriteFile(oldFile+"."+oldTime.UTC().Format("20060102T150405Z")+".gz", []byte("older"), 0644))
+	require.NoError(t, os.Chtimes(oldFile, oldTime, oldTime))
+
+	return dir
+}
+
+// newTestContext creates a CLI context for the given command which contains the flag defaults and the given arguments.
+func newTestContext(t *testing.T, command *cli.Command, args ...string) *cli.Context {
+	t.Helper()
+
+	flagSet := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	for _, f := range command.Flags {
+		// slice flags keep their values between parses, so every context gets a fresh copy
+		if sliceFlag, ok := f.(*cli.StringSliceFlag); ok {
+			fresh := *sliceFlag
+			fresh.Value = nil
+			f = &fresh
+		}
+		require.NoError(t, f.Apply(flagSet))
+	}
+	require.NoError(t, flagSet.Parse(args))
+
+	c := cli.NewContext(cli.NewApp(), flagSet, nil)
+	c.Command = command
+	return c
+}
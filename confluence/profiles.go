@@ -0,0 +1,115 @@
+// Package confluence knows the directories of a Confluence instance that collect files over time and how tempdel
+// should clean them up.
+package confluence
+
+import (
+	"fmt"
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	day = 24 * time.Hour
+	// datePattern matches the date that Tomcat puts into the names of its daily log files.
+	datePattern = "[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]"
+)
+
+// Profile bundles the deletion settings for a known directory of Confluence. Settings which are not set keep the
+// defaults of tempdel.
+type Profile struct {
+	// Name identifies the profile on the command line.
+	Name string
+	// Description tells which files the profile cleans up.
+	Description string
+	// Location names the usual path of the directory inside the Confluence installation, home or shared home
+	// directory.
+	Location string
+	// MaxAge sets how old at least a file must be before it will be deleted. It is ignored if Stages are set.
+	MaxAge time.Duration
+	// Stages optionally replaces MaxAge with a lifecycle of actions.
+	Stages []deletion.Stage
+	// MinDirectoryAge sets how long an empty directory must have stayed unchanged before it will be deleted.
+	MinDirectoryAge time.Duration
+	// KeepEmptyDirectories protects empty directories that Confluence or Tomcat expect to exist.
+	KeepEmptyDirectories bool
+	// SkipOpenFiles protects files that are still written by Confluence although their names look like leftovers.
+	SkipOpenFiles bool
+	// Include restricts the deletion to the files that the profile is meant for.
+	Include []string
+	// Exclude protects files that are still in use by Confluence.
+	Exclude []string
+}
+
+var profiles = []Profile{
+	{
+		Name:            "temp",
+		Description:     "Temporary files like space exports, imports and upload chunks",
+		Location:        "<confluence-install>/temp",
+		MaxAge:          12 * time.Hour,
+		MinDirectoryAge: 10 * time.Minute,
+	},
+	{
+		Name:        "work",
+		Description: "Leftover upload files of Tomcat; compiled JSPs and persisted sessions are kept",
+		Location:    "<confluence-install>/work",
+		MaxAge:      day,
+		// Tomcat recreates its work directories only on startup
+		KeepEmptyDirectories: true,
+		Include:              []string{"**/upload_*.tmp"},
+	},
+	{
+		Name:        "logs",
+		Description: "Rotated log files of Confluence, Synchrony and Tomcat",
+		Location:    "<confluence-home>/logs, <confluence-install>/logs",
+		Stages: []deletion.Stage{
+			{Action: deletion.StageActionCompress, Age: deletion.Duration(day)},
+			{Action: deletion.StageActionDelete, Age: deletion.Duration(30 * day)},
+		},
+		KeepEmptyDirectories: true,
+		// only rotated files: numbered ones like atlassian-confluence.log.1 and Tomcat's daily ones like
+		// catalina.2024-01-31.log
+		Include: []string{"**/*.log.[0-9]*", "**/*." + datePattern + ".log", "**/*." + datePattern + ".log.gz",
+			"**/*." + datePattern + ".txt", "**/*." + datePattern + ".txt.gz"},
+		// log4j keeps every appender's current file open, even if it is written only rarely
+		Exclude:       []string{"**/atlassian-*.log", "**/catalina.out"},
+		SkipOpenFiles: true,
+	},
+	{
+		Name:                 "backups",
+		Description:          "Daily XML backups created by the scheduled backup job",
+		Location:             "<confluence-home>/backups",
+		MaxAge:               14 * day,
+		KeepEmptyDirectories: true,
+		Include:              []string{"backup-*.zip"},
+	},
+	{
+		Name:            "export",
+		Description:     "Space and site exports in the shared home of a Data Center cluster",
+		Location:        "<confluence-shared-home>/export",
+		MaxAge:          day,
+		MinDirectoryAge: time.Hour,
+	},
+}
+
+// Profiles returns all built-in profiles ordered by name.
+func Profiles() []Profile {
+	result := append([]Profile(nil), profiles...)
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result
+}
+
+// LookupProfile returns the built-in profile with the given name.
+func LookupProfile(name string) (Profile, error) {
+	var names []string
+	for _, profile := range Profiles() {
+		if profile.Name == strings.ToLower(strings.TrimSpace(name)) {
+			return profile, nil
+		}
+		names = append(names, profile.Name)
+	}
+
+	return Profile{}, fmt.Errorf("unknown profile %q: expected one of %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,95 @@
+package confluence
+
+import (
+	"context"
+	"github.com/cloudogu/confluence-temp-delete-job/deletion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProfiles(t *testing.T) {
+	t.Run("should return valid profiles ordered by name", func(t *testing.T) {
+		actual := Profiles()
+
+		require.NotEmpty(t, actual)
+		for i, profile := range actual {
+			if i > 0 {
+				assert.Less(t, actual[i-1].Name, profile.Name)
+			}
+			_, err := deletion.New(deletion.Args{
+				Directory:            "/tmp",
+				MaxAge:               profile.MaxAge,
+				Stages:               profile.Stages,
+				MinDirectoryAge:      profile.MinDirectoryAge,
+				KeepEmptyDirectories: profile.KeepEmptyDirectories,
+				SkipOpenFiles:        profile.SkipOpenFiles,
+				Include:              profile.Include,
+				Exclude:              profile.Exclude,
+			})
+			assert.NoError(t, err, "profile %s", profile.Name)
+		}
+	})
+}
+
+func TestProfiles_logs(t *testing.T) {
+	t.Run("should only compress rotated log files", func(t *testing.T) {
+		// given
+		dir := t.TempDir()
+		oldTime := time.Now().Add(-3 * day)
+		files := map[string]bool{
+			"atlassian-confluence.log":               false,
+			"atlassian-confluence-security.log":      false,
+			"atlassian-confluence-outgoing-mail.log": false,
+			"catalina.out":                           false,
+			"atlassian-confluence.log.1":             true,
+			"catalina.2024-01-31.log":                true,
+			"localhost_access_log.2024-01-31.txt":    true,
+		}
+		for name := range files {
+			file := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(file, []byte("log line\n"), 0644))
+			require.NoError(t, os.Chtimes(file, oldTime, oldTime))
+		}
+		profile, err := LookupProfile("logs")
+		require.NoError(t, err)
+		sut, err := deletion.New(deletion.Args{
+			Directory:            dir,
+			Stages:               profile.Stages,
+			KeepEmptyDirectories: profile.KeepEmptyDirectories,
+			SkipOpenFiles:        profile.SkipOpenFiles,
+			Include:              profile.Include,
+			Exclude:              profile.Exclude,
+		})
+		require.NoError(t, err)
+
+		// when
+		_, err = sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		for name, compressed := range files {
+			_, err := os.Stat(filepath.Join(dir, name+".gz"))
+			assert.Equal(t, compressed, err == nil, name)
+		}
+	})
+}
+
+func TestLookupProfile(t *testing.T) {
+	t.Run("should find a profile by name", func(t *testing.T) {
+		actual, err := LookupProfile(" Temp")
+
+		require.NoError(t, err)
+		assert.Equal(t, "temp", actual.Name)
+		assert.Equal(t, 12*time.Hour, actual.MaxAge)
+	})
+	t.Run("should fail on unknown profiles", func(t *testing.T) {
+		_, err := LookupProfile("attachments")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown profile "attachments": expected one of backups, export, logs, temp, work`)
+	})
+}
@@ -0,0 +1,221 @@
+package confluence
+
+import (
+	"bufio"
+	errors2 "github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// initPropertiesFile names the file inside the installation directory that points to the home directory.
+	initPropertiesFile = "confluence/WEB-INF/classes/confluence-init.properties"
+	// homeConfigFile names the file that every set-up home directory contains.
+	homeConfigFile = "confluence.cfg.xml"
+)
+
+var (
+	// defaultInstallDirectory is used if neither CATALINA_BASE nor CATALINA_HOME are set.
+	defaultInstallDirectory = "/opt/atlassian/confluence"
+	// tmpDirOptionPattern finds the java.io.tmpdir system property in JVM options, with or without quotes.
+	tmpDirOptionPattern = regexp.MustCompile(`-Djava\.io\.tmpdir=("[^"]*"|'[^']*'|[^\s"']+)`)
+	// tmpDirVariablePattern finds an assignment of CATALINA_TMPDIR in a shell script, with or without quotes.
+	tmpDirVariablePattern = regexp.MustCompile(`^(?:export\s+)?CATALINA_TMPDIR=("[^"]*"|'[^']*'|[^\s"';]+)`)
+)
+
+// TempDirectory describes the temp directory that Confluence uses and how it was found.
+type TempDirectory struct {
+	Path string
+	// Source tells where the path was found, f. e. "environment variable CATALINA_TMPDIR".
+	Source string
+}
+
+// DetectTempDirectory finds the temp directory that Confluence actually uses. The first of these sources that names
+// an existing directory wins:
+//
+//  1. CATALINA_TMPDIR in bin/setenv.sh of the installation directory
+//  2. the environment variable CATALINA_TMPDIR
+//  3. the directory temp of the installation directory which Tomcat uses by default
+//  4. -Djava.io.tmpdir in the environment variables CATALINA_OPTS or JAVA_OPTS
+//  5. -Djava.io.tmpdir in bin/setenv.sh of the installation directory
+//  6. the directory temp of the home directory named by CONFLUENCE_HOME or confluence-init.properties
+//
+// catalina.sh reads setenv.sh after the environment and passes -Djava.io.tmpdir with CATALINA_TMPDIR or its default
+// after CATALINA_OPTS and JAVA_OPTS, so the options only count if Tomcat is started without catalina.sh.
+//
+// The installation directory is taken from CATALINA_BASE or CATALINA_HOME and defaults to /opt/atlassian/confluence.
+func DetectTempDirectory() (TempDirectory, error) {
+	installDirectory := findInstallDirectory()
+	setenvFile := ""
+	setenvTmpDir, setenvTmpDirOption := "", ""
+	if installDirectory != "" {
+		setenvFile = filepath.Join(installDirectory, "bin", "setenv.sh")
+		var err error
+		setenvTmpDir, setenvTmpDirOption, err = tmpDirFromSetenv(setenvFile, installDirectory)
+		if err != nil {
+			return TempDirectory{}, err
+		}
+	}
+
+	if setenvTmpDir != "" && isDirectory(setenvTmpDir) {
+		return TempDirectory{Path: setenvTmpDir, Source: "CATALINA_TMPDIR in " + setenvFile}, nil
+	}
+	if tmpDir := os.Getenv("CATALINA_TMPDIR"); tmpDir != "" && isDirectory(tmpDir) {
+		return TempDirectory{Path: tmpDir, Source: "environment variable CATALINA_TMPDIR"}, nil
+	}
+	if installDirectory != "" && isDirectory(filepath.Join(installDirectory, "temp")) {
+		return TempDirectory{Path: filepath.Join(installDirectory, "temp"),
+			Source: "default temp directory of Tomcat in " + installDirectory}, nil
+	}
+
+	for _, name := range []string{"CATALINA_OPTS", "JAVA_OPTS"} {
+		if tmpDir := tmpDirOption(os.Getenv(name), installDirectory); tmpDir != "" && isDirectory(tmpDir) {
+			return TempDirectory{Path: tmpDir, Source: "-Djava.io.tmpdir in environment variable " + name}, nil
+		}
+	}
+	if setenvTmpDirOption != "" && isDirectory(setenvTmpDirOption) {
+		return TempDirectory{Path: setenvTmpDirOption, Source: "-Djava.io.tmpdir in " + setenvFile}, nil
+	}
+
+	homeDirectory, source, err := findHomeDirectory(installDirectory)
+	if err != nil {
+		return TempDirectory{}, err
+	}
+	if homeDirectory != "" && isDirectory(filepath.Join(homeDirectory, "temp")) {
+		return TempDirectory{Path: filepath.Join(homeDirectory, "temp"), Source: source}, nil
+	}
+
+	return TempDirectory{}, errors2.New("could not detect the temp directory of Confluence: neither CATALINA_TMPDIR " +
+		"nor -Djava.io.tmpdir are set and no installation or home directory was found; set CATALINA_HOME or pass " +
+		"the directory as argument")
+}
+
+// findInstallDirectory returns the installation directory of Confluence or an empty string if there is none.
+func findInstallDirectory() string {
+	for _, name := range []string{"CATALINA_BASE", "CATALINA_HOME"} {
+		if directory := os.Getenv(name); directory != "" {
+			return directory
+		}
+	}
+	if isDirectory(filepath.Join(defaultInstallDirectory, "bin")) {
+		return defaultInstallDirectory
+	}
+
+	return ""
+}
+
+// findHomeDirectory returns the home directory of Confluence together with its source. Only directories containing
+// confluence.cfg.xml count as home directory.
+func findHomeDirectory(installDirectory string) (string, string, error) {
+	if home := os.Getenv("CONFLUENCE_HOME"); home != "" && isFile(filepath.Join(home, homeConfigFile)) {
+		return home, "home directory in environment variable CONFLUENCE_HOME", nil
+	}
+	if installDirectory == "" {
+		return "", "", nil
+	}
+
+	propertiesFile := filepath.Join(installDirectory, filepath.FromSlash(initPropertiesFile))
+	home, err := readProperty(propertiesFile, "confluence.home")
+	if err != nil {
+		return "", "", err
+	}
+	if home != "" && isFile(filepath.Join(home, homeConfigFile)) {
+		return home, "confluence.home in " + propertiesFile, nil
+	}
+
+	return "", "", nil
+}
+
+// tmpDirFromSetenv returns the last assignment of CATALINA_TMPDIR and the last java.io.tmpdir in the given setenv.sh
+// because the shell and the JVM both use the last occurrence. A missing file sets nothing.
+func tmpDirFromSetenv(setenvFile, installDirectory string) (string, string, error) {
+	file, err := os.Open(setenvFile)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", errors2.Wrapf(err, "could not read %s", setenvFile)
+	}
+	defer func() { _ = file.Close() }()
+
+	tmpDir, tmpDirFromOption := "", ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if match := tmpDirVariablePattern.FindStringSubmatch(line); match != nil {
+			tmpDir = expandPath(match[1], installDirectory)
+		}
+		if found := tmpDirOption(line, installDirectory); found != "" {
+			tmpDirFromOption = found
+		}
+	}
+
+	return tmpDir, tmpDirFromOption, scanner.Err()
+}
+
+// tmpDirOption returns the last java.io.tmpdir in the given JVM options.
+func tmpDirOption(options, installDirectory string) string {
+	matches := tmpDirOptionPattern.FindAllStringSubmatch(options, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	return expandPath(matches[len(matches)-1][1], installDirectory)
+}
+
+// expandPath removes the quotes around the given shell value and expands its variables. The variables CATALINA_BASE
+// and CATALINA_HOME are expanded to the installation directory, other variables to their values in the environment.
+func expandPath(value, installDirectory string) string {
+	return os.Expand(strings.Trim(value, `"'`), func(name string) string {
+		if (name == "CATALINA_BASE" || name == "CATALINA_HOME") && installDirectory != "" {
+			return installDirectory
+		}
+		return os.Getenv(name)
+	})
+}
+
+// readProperty returns the value of the given key in a Java properties file. A missing file or key returns an empty
+// value.
+func readProperty(propertiesFile, key string) (string, error) {
+	file, err := os.Open(propertiesFile)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors2.Wrapf(err, "could not read %s", propertiesFile)
+	}
+	defer func() { _ = file.Close() }()
+
+	value := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		separator := strings.IndexAny(line, "=:")
+		if separator == -1 || strings.TrimSpace(line[:separator]) != key {
+			continue
+		}
+		// Windows paths are written like C\:\\confluence\\home
+		value = strings.NewReplacer(`\\`, `\`, `\:`, `:`, `\=`, `=`).Replace(strings.TrimSpace(line[separator+1:]))
+	}
+
+	return value, scanner.Err()
+}
+
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,187 @@
+package confluence
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectTempDirectory(t *testing.T) {
+	// setupInstallation creates an empty installation directory and a home directory and clears all environment
+	// variables that the detection reads.
+	setupInstallation := func(t *testing.T) (string, string) {
+		t.Helper()
+
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-confluence-")
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		installDir := filepath.Join(dir, "install")
+		homeDir := filepath.Join(dir, "home")
+		require.NoError(t, os.MkdirAll(filepath.Join(installDir, "bin"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(homeDir, "temp"), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(homeDir, homeConfigFile), []byte("<confluence-configuration/>"), 0644))
+
+		for _, name := range []string{"CATALINA_TMPDIR", "CATALINA_OPTS", "JAVA_OPTS", "CATALINA_BASE", "CATALINA_HOME",
+			"CONFLUENCE_HOME"} {
+			t.Setenv(name, "")
+		}
+		originalInstallDirectory := defaultInstallDirectory
+		defaultInstallDirectory = installDir
+		t.Cleanup(func() { defaultInstallDirectory = originalInstallDirectory })
+
+		return installDir, homeDir
+	}
+	writeFile := func(t *testing.T, file, content string) {
+		t.Helper()
+
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+	}
+
+	t.Run("should prefer CATALINA_TMPDIR", func(t *testing.T) {
+		installDir, _ := setupInstallation(t)
+		tmpDir := filepath.Join(installDir, "catalina-temp")
+		require.NoError(t, os.Mkdir(tmpDir, 0755))
+		t.Setenv("CATALINA_TMPDIR", tmpDir)
+		t.Setenv("CATALINA_OPTS", "-Djava.io.tmpdir=/other")
+
+		actual, err := DetectTempDirectory()
+
+		require.NoError(t, err)
+		assert.Equal(t, TempDirectory{Path: tmpDir, Source: "environment variable CATALINA_TMPDIR"}, actual)
+	})
+	t.Run("should prefer CATALINA_TMPDIR in setenv.sh", func(t *testing.T) {
+		installDir, _ := setupInstallation(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(installDir, "temp"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(installDir, "setenv-temp"), 0755))
+		envTmpDir := filepath.Join(installDir, "catalina-temp")
+		require.NoError(t, os.Mkdir(envTmpDir, 0755))
+		t.Setenv("CATALINA_TMPDIR", envTmpDir)
+		setenvFile := filepath.Join(installDir, "bin", "setenv.sh")
+		writeFile(t, setenvFile, "# CATALINA_TMPDIR=/commented\n"+
+			"CATALINA_TMPDIR=/first\n"+
+			"export CATALINA_TMPDIR=\"${CATALINA_HOME}/setenv-temp\"\n")
+
+		actual, err := DetectTempDirectory()
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(installDir, "setenv-temp"), actual.Path)
+		assert.Equal(t, "CATALINA_TMPDIR in "+setenvFile, actual.Source)
+	})
+	t.Run("should ignore CATALINA_TMPDIR if the directory does not exist", func(t *testing.T) {
+		installDir, _ := setupInstallation(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(installDir, "temp"), 0755))
+		t.Setenv("CATALINA_TMPDIR", filepath.Join(installDir, "missing"))
+		writeFile(t, filepath.Join(installDir, "bin", "setenv.sh"), "CATALINA_TMPDIR=/does/not/exist\n")
+
+		actual, err := DetectTempDirectory()
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(installDir, "temp"), actual.Path)
+	})
+	t.Run("should prefer the temp directory of Tomcat over java.io.tmpdir", func(t *testing.T) {
+		installDir, _ := setupInstallation(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(installDir, "temp"), 0755))
+		t.Setenv("CATALINA_OPTS", "-Djava.io.tmpdir="+installDir)
+
+		actual, err := DetectTempDirectory()
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(installDir, "temp"), actual.Path)
+	})
+	t.Run("should read java.io.tmpdir from CATALINA_OPTS", func(t *testing.T) {
+		installDir, _ := setupInstallation(t)
+		t.Setenv("CATALINA_OPTS", "-Xmx2g -Djava.io.tmpdir='"+installDir+"' -Dfile.encoding=UTF-8")
+
+		actual, err := DetectTempDirectory()
+
+		require.NoError(t, err)
+		assert.Equal(t, installDir, actual.Path)
+		assert.Equal(t, "-Djava.io.tmpdir in environment variable CATALINA_OPTS", actual.Source)
+	})
+	t.Run("should ignore java.io.tmpdir if the directory does not exist", func(t *testing.T) {
+		setupInstallation(t)
+		t.Setenv("CATALINA_OPTS", "-Djava.io.tmpdir=/does/not/exist")
+		t.Setenv("JAVA_OPTS", "-Djava.io.tmpdir=/does/not/exist/either")
+
+		_, err := DetectTempDirectory()
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not detect the temp directory of Confluence")
+	})
+	t.Run("should read the last java.io.tmpdir from setenv.sh", func(t *testing.T) {
+		installDir, _ := setupInstallation(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(installDir, "custom-temp"), 0755))
+		setenvFile := filepath.Join(installDir, "bin", "setenv.sh")
+		writeFile(t, setenvFile, "# CATALINA_OPTS=\"-Djava.io.tmpdir=/commented\"\n"+
+			"CATALINA_OPTS=\"-Djava.io.tmpdir=/first ${CATALINA_OPTS}\"\n"+
+			"CATALINA_OPTS=\"-Djava.io.tmpdir=${CATALINA_BASE}/custom-temp ${CATALINA_OPTS}\"\n"+
+			"export CATALINA_OPTS\n")
+
+		actual, err := DetectTempDirectory()
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(installDir, "custom-temp"), actual.Path)
+		assert.Equal(t, "-Djava.io.tmpdir in "+setenvFile, actual.Source)
+	})
+	t.Run("should fall back to the temp directory of Tomcat", func(t *testing.T) {
+		installDir, _ := setupInstallation(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(installDir, "temp"), 0755))
+		t.Setenv("CATALINA_HOME", installDir)
+		defaultInstallDirectory = "/does/not/exist"
+
+		actual, err := DetectTempDirectory()
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(installDir, "temp"), actual.Path)
+		assert.Equal(t, "default temp directory of Tomcat in "+installDir, actual.Source)
+	})
+	t.Run("should fall back to the home directory from confluence-init.properties", func(t *testing.T) {
+		installDir, homeDir := setupInstallation(t)
+		propertiesFile := filepath.Join(installDir, filepath.FromSlash(initPropertiesFile))
+		writeFile(t, propertiesFile, "# confluence.home=/commented\nconfluence.home = "+homeDir+"\n")
+
+		actual, err := DetectTempDirectory()
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(homeDir, "temp"), actual.Path)
+		assert.Equal(t, "confluence.home in "+propertiesFile, actual.Source)
+	})
+	t.Run("should fall back to CONFLUENCE_HOME", func(t *testing.T) {
+		_, homeDir := setupInstallation(t)
+		t.Setenv("CONFLUENCE_HOME", homeDir)
+
+		actual, err := DetectTempDirectory()
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(homeDir, "temp"), actual.Path)
+		assert.Equal(t, "home directory in environment variable CONFLUENCE_HOME", actual.Source)
+	})
+	t.Run("should ignore home directories without confluence.cfg.xml", func(t *testing.T) {
+		_, homeDir := setupInstallation(t)
+		require.NoError(t, os.Remove(filepath.Join(homeDir, homeConfigFile)))
+		t.Setenv("CONFLUENCE_HOME", homeDir)
+
+		_, err := DetectTempDirectory()
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not detect the temp directory of Confluence")
+	})
+}
+
+func Test_readProperty(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-confluence-")
+	defer func() { _ = os.RemoveAll(dir) }()
+	file := filepath.Join(dir, "confluence-init.properties")
+	_ = ioutil.WriteFile(file, []byte("! comment\nconfluence.home=C\\:\\\\confluence\\\\home\n"), 0644)
+
+	actual, err := readProperty(file, "confluence.home")
+	require.NoError(t, err)
+	assert.Equal(t, `C:\confluence\home`, actual)
+
+	actual, err = readProperty(filepath.Join(dir, "missing"), "confluence.home")
+	require.NoError(t, err)
+	assert.Empty(t, actual)
+}
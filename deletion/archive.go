@@ -0,0 +1,337 @@
+package deletion
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	errors2 "github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ArchiveFormatTarGzip packs the files into a gzip-compressed tar bundle.
+	ArchiveFormatTarGzip ArchiveFormat = "tar.gz"
+	// ArchiveFormatTarZstd packs the files into a zstd-compressed tar bundle.
+	ArchiveFormatTarZstd ArchiveFormat = "tar.zst"
+
+	archiveFilePrefix = "tempdel-"
+	// archiveManifestEntry names the last entry of each bundle which records the original location of every file.
+	archiveManifestEntry = "manifest.jsonl"
+	// archiveFilesDirectory contains the files of a bundle below their path relative to the start directory.
+	archiveFilesDirectory = "files"
+)
+
+// ArchiveFormat selects the compression of the bundles in archive mode.
+type ArchiveFormat string
+
+// ParseArchiveFormat checks the given archive format. Empty selects tar.gz.
+func ParseArchiveFormat(format string) (ArchiveFormat, error) {
+	switch ArchiveFormat(strings.ToLower(strings.TrimSpace(format))) {
+	case "", ArchiveFormatTarGzip:
+		return ArchiveFormatTarGzip, nil
+	case ArchiveFormatTarZstd:
+		return ArchiveFormatTarZstd, nil
+	default:
+		return "", fmt.Errorf("invalid archive format %q: expected %s or %s", format, ArchiveFormatTarGzip,
+			ArchiveFormatTarZstd)
+	}
+}
+
+// archiveEntry describes a file in the manifest of a bundle.
+type archiveEntry struct {
+	// OriginalPath contains the absolute path from which the file was archived.
+	OriginalPath string `json:"originalPath"`
+	// ArchivePath contains the name of the file inside the bundle.
+	ArchivePath string    `json:"archivePath"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+}
+
+// archivedPath is a selected path that will be deleted once the bundle is written.
+type archivedPath struct {
+	path     string
+	info     os.FileInfo
+	pass     func(path string, info os.FileInfo, checksum string)
+	checksum string
+}
+
+// archiver collects the selected paths of a run in archive mode. The paths are collected in post-order, so that
+// directories always follow their entries. It may be used by several goroutines at once.
+type archiver struct {
+	mutex sync.Mutex
+	paths []archivedPath
+}
+
+func (a *archiver) add(path string, info os.FileInfo, pass func(path string, info os.FileInfo, checksum string),
+	checksum string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.paths = append(a.paths, archivedPath{path: path, info: info, pass: pass, checksum: checksum})
+}
+
+// archiveAndDelete packs all collected files into a bundle and deletes the collected paths only after the bundle was
+// written completely. Files that could not be packed are recorded as failed and kept. If the given context is
+// cancelled, the bundle is discarded and nothing is deleted, or the deletion stops at the next path.
+func (d *deleter) archiveAndDelete(ctx context.Context, runTime time.Time) error {
+	paths := d.archive.paths
+	d.archive = nil
+
+	bundle, notArchived, err := writeArchive(ctx, d.ArchiveDirectory, d.ArchiveFormat, d.Directory, runTime, paths)
+	if err != nil {
+		return errors2.Wrap(err, "could not write archive, so nothing was deleted")
+	}
+	if bundle != "" {
+		d.Results.archive(bundle)
+		pruneArchives(d.ArchiveDirectory, d.ArchiveKeep)
+	}
+
+	for _, archived := range paths {
+		if d.budgetExceeded.Load() || ctx.Err() != nil {
+			break
+		}
+
+		if packErr, ok := notArchived[archived.path]; ok {
+			d.Results.remain(archived.info.Size())
+			err = d.failPath(archived.path, errors2.Wrapf(packErr, "could not archive path %q", archived.path))
+		} else {
+			var removed bool
+			removed, err = d.deletePath(archived.path, archived.info, archived.pass, archived.checksum)
+			if !removed && !archived.info.IsDir() {
+				d.Results.remain(archived.info.Size())
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeArchive packs the given files into a new bundle in the archive directory and returns its path. Directories are
+// not packed. It returns an empty path if there are no files. Files that vanished or could not be read are left out of
+// the bundle and returned with their errors. A cancelled context discards the bundle.
+func writeArchive(ctx context.Context, archiveDirectory string, format ArchiveFormat, startDirectory string, runTime time.Time,
+	paths []archivedPath) (string, map[string]error, error) {
+	notArchived := map[string]error{}
+	hasFiles := false
+	for _, archived := range paths {
+		hasFiles = hasFiles || !archived.info.IsDir()
+	}
+	if !hasFiles {
+		return "", notArchived, nil
+	}
+
+	err := os.MkdirAll(archiveDirectory, 0750)
+	if err != nil {
+		return "", nil, err
+	}
+
+	bundleName := archiveFilePrefix + runTime.UTC().Format(quarantineRunIDFormat) + "." + string(format)
+	bundle := filepath.Join(archiveDirectory, bundleName)
+	partFile := bundle + ".part"
+	file, err := os.OpenFile(partFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = writeBundle(ctx, file, format, startDirectory, paths, notArchived)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partFile, bundle)
+	}
+	if err != nil {
+		_ = os.Remove(partFile)
+		return "", nil, err
+	}
+
+	return bundle, notArchived, nil
+}
+
+func writeBundle(ctx context.Context, writer io.Writer, format ArchiveFormat, startDirectory string,
+	paths []archivedPath, notArchived map[string]error) error {
+	var compressor io.WriteCloser
+	if format == ArchiveFormatTarZstd {
+		var err error
+		compressor, err = zstd.NewWriter(writer)
+		if err != nil {
+			return err
+		}
+	} else {
+		compressor = gzip.NewWriter(writer)
+	}
+
+	tarWriter := tar.NewWriter(compressor)
+	var manifest []archiveEntry
+	for _, archived := range paths {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if archived.info.IsDir() {
+			continue
+		}
+
+		entry, err := addToBundle(ctx, tarWriter, startDirectory, archived)
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			notArchived[archived.path] = err
+			continue
+		}
+		if err != nil {
+			return errors2.Wrapf(err, "could not add %q to archive", archived.path)
+		}
+		manifest = append(manifest, entry)
+	}
+
+	err := addManifestToBundle(tarWriter, manifest)
+	if err != nil {
+		return err
+	}
+	err = tarWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	return compressor.Close()
+}
+
+// addToBundle writes a single file into the bundle. Errors while opening the file leave the bundle intact. Copying a
+// large file stops as soon as the given context is cancelled.
+func addToBundle(ctx context.Context, tarWriter *tar.Writer, startDirectory string, archived archivedPath) (archiveEntry, error) {
+	relPath, err := filepath.Rel(startDirectory, archived.path)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	originalPath, err := filepath.Abs(archived.path)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+
+	link := ""
+	var content *os.File
+	if archived.info.Mode()&os.ModeSymlink != 0 {
+		link, err = os.Readlink(archived.path)
+	} else if archived.info.Mode().IsRegular() {
+		content, err = os.Open(archived.path)
+	}
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	if content != nil {
+		defer func() { _ = content.Close() }()
+	}
+
+	header, err := tar.FileInfoHeader(archived.info, link)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	header.Name = archiveFilesDirectory + "/" + filepath.ToSlash(relPath)
+
+	err = tarWriter.WriteHeader(header)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	if content != nil {
+		// a file that shrank in the meantime would corrupt the bundle, so the whole bundle fails in this case
+		_, err = io.CopyN(tarWriter, &contextReader{ctx: ctx, reader: content}, header.Size)
+		if err != nil {
+			return archiveEntry{}, err
+		}
+	}
+
+	return archiveEntry{
+		OriginalPath: originalPath,
+		ArchivePath:  header.Name,
+		Size:         archived.info.Size(),
+		ModTime:      archived.info.ModTime(),
+	}, nil
+}
+
+// contextReader stops reading as soon as its context is cancelled.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, r.ctx.Err()
+	}
+
+	return r.reader.Read(p)
+}
+
+func addManifestToBundle(tarWriter *tar.Writer, manifest []archiveEntry) error {
+	var content []byte
+	for _, entry := range manifest {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		content = append(append(content, line...), '\n')
+	}
+
+	err := tarWriter.WriteHeader(&tar.Header{
+		Name:    archiveManifestEntry,
+		Mode:    0640,
+		Size:    int64(len(content)),
+		ModTime: nowClock.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tarWriter.Write(content)
+
+	return err
+}
+
+// pruneArchives deletes the oldest bundles in the archive directory so that at most the given number of bundles
+// remain. Zero or less keeps all bundles. Bundles that cannot be deleted are logged and retried with the next run.
+func pruneArchives(archiveDirectory string, keep int) {
+	if keep <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(archiveDirectory)
+	if err != nil {
+		log.Warningf("could not read archive directory: %v", err)
+		return
+	}
+
+	var bundles []string
+	for _, entry := range entries {
+		name := entry.Name()
+		isBundle := strings.HasSuffix(name, "."+string(ArchiveFormatTarGzip)) ||
+			strings.HasSuffix(name, "."+string(ArchiveFormatTarZstd))
+		if strings.HasPrefix(name, archiveFilePrefix) && isBundle && entry.Type().IsRegular() {
+			bundles = append(bundles, name)
+		}
+	}
+	// the names contain the run time, so they sort chronologically
+	sort.Strings(bundles)
+
+	for len(bundles) > keep {
+		err = os.Remove(filepath.Join(archiveDirectory, bundles[0]))
+		if err != nil {
+			log.Warningf("could not delete old archive %s: %v", bundles[0], err)
+		} else {
+			log.Infof("deleted old archive %s", bundles[0])
+		}
+		bundles = bundles[1:]
+	}
+}
@@ -0,0 +1,191 @@
+package deletion
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_deleter_Execute_withArchive(t *testing.T) {
+	for _, format := range []ArchiveFormat{ArchiveFormatTarGzip, ArchiveFormatTarZstd} {
+		t.Run("should pack old files into a "+string(format)+" bundle before deleting them", func(t *testing.T) {
+			// given
+			startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+			defer func() { _ = os.RemoveAll(startDir) }()
+			archiveDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-archive-")
+			defer func() { _ = os.RemoveAll(archiveDir) }()
+			oldTime := nowClock.Now().Add(-20 * time.Hour)
+			exportDir := filepath.Join(startDir, "export")
+			_ = os.MkdirAll(exportDir, 0755)
+			deleteFile1 := createFileWithSizeAndTime(t, exportDir, "a-old", 42, oldTime)
+			leaveFile1 := createFileWithTime(t, startDir, "b-new", nowClock.Now())
+
+			sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, ArchiveDirectory: archiveDir, ArchiveFormat: format})
+
+			// when
+			actual, err := sut.Execute(context.Background())
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, 2, actual.deleted)
+			assertFileNotExists(t, deleteFile1)
+			assertFileNotExists(t, exportDir)
+			assertFileExists(t, leaveFile1)
+
+			require.NotEmpty(t, actual.archiveFile)
+			assert.Equal(t, archiveDir, filepath.Dir(actual.archiveFile))
+			entries := readBundle(t, actual.archiveFile, format)
+			assert.Equal(t, 42, len(entries["files/export/"+filepath.Base(deleteFile1)]))
+			assert.Contains(t, string(entries["manifest.jsonl"]), `"originalPath":"`+deleteFile1+`"`)
+			assert.Len(t, entries, 2)
+		})
+	}
+	t.Run("should delete nothing if the bundle cannot be written", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		archiveDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-archive-")
+		defer func() { _ = os.RemoveAll(archiveDir) }()
+		// a file blocks the creation of the archive directory
+		blockedArchiveDir := filepath.Join(archiveDir, "blocked")
+		require.NoError(t, ioutil.WriteFile(blockedArchiveDir, nil, 0644))
+		deleteFile1 := createFileWithTime(t, startDir, "a-old", nowClock.Now().Add(-20*time.Hour))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, ArchiveDirectory: blockedArchiveDir})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not write archive, so nothing was deleted")
+		assert.Equal(t, 0, actual.deleted)
+		assertFileExists(t, deleteFile1)
+	})
+	t.Run("should discard the bundle and delete nothing if the run is interrupted", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		archiveDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-archive-")
+		defer func() { _ = os.RemoveAll(archiveDir) }()
+		deleteFile1 := createFileWithTime(t, startDir, "a-old", nowClock.Now().Add(-20*time.Hour))
+		info, _ := os.Lstat(deleteFile1)
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, ArchiveDirectory: archiveDir})
+		sut.archive = &archiver{}
+		sut.archive.add(deleteFile1, info, sut.Results.pass, "")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// when
+		err := sut.archiveAndDelete(ctx, nowClock.Now())
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not write archive, so nothing was deleted: context canceled")
+		assert.Equal(t, 0, sut.Results.deleted)
+		assertFileExists(t, deleteFile1)
+		bundles, _ := ioutil.ReadDir(archiveDir)
+		assert.Empty(t, bundles)
+	})
+	t.Run("should not create a bundle without old files", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		archiveDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-archive-")
+		defer func() { _ = os.RemoveAll(archiveDir) }()
+		createFileWithTime(t, startDir, "a-new", nowClock.Now())
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, ArchiveDirectory: archiveDir})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, actual.archiveFile)
+		bundles, _ := ioutil.ReadDir(archiveDir)
+		assert.Empty(t, bundles)
+	})
+}
+
+func Test_pruneArchives(t *testing.T) {
+	archiveDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-archive-")
+	defer func() { _ = os.RemoveAll(archiveDir) }()
+	names := []string{
+		"tempdel-20210301T030300.000Z.tar.gz",
+		"tempdel-20210302T030300.000Z.tar.zst",
+		"tempdel-20210303T030300.000Z.tar.gz",
+		"tempdel-20210304T030300.000Z.tar.gz.part",
+		"other.tar.gz",
+	}
+	for _, name := range names {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(archiveDir, name), nil, 0644))
+	}
+
+	// when
+	pruneArchives(archiveDir, 2)
+
+	// then
+	assertFileNotExists(t, filepath.Join(archiveDir, names[0]))
+	for _, name := range names[1:] {
+		assertFileExists(t, filepath.Join(archiveDir, name))
+	}
+}
+
+func TestParseArchiveFormat(t *testing.T) {
+	actual, err := ParseArchiveFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, ArchiveFormatTarGzip, actual)
+
+	actual, err = ParseArchiveFormat("TAR.ZST")
+	require.NoError(t, err)
+	assert.Equal(t, ArchiveFormatTarZstd, actual)
+
+	_, err = ParseArchiveFormat("zip")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid archive format "zip"`)
+}
+
+// readBundle returns the contents of all entries in the given bundle by their names.
+func readBundle(t *testing.T, bundle string, format ArchiveFormat) map[string][]byte {
+	t.Helper()
+
+	file, err := os.Open(bundle)
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	var reader io.Reader
+	if format == ArchiveFormatTarZstd {
+		decoder, err := zstd.NewReader(file)
+		require.NoError(t, err)
+		defer decoder.Close()
+		reader = decoder
+	} else {
+		decompressor, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		reader = decompressor
+	}
+
+	entries := map[string][]byte{}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(tarReader)
+		require.NoError(t, err)
+		entries[header.Name] = content
+	}
+}
@@ -0,0 +1,164 @@
+package deletion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	errors2 "github.com/pkg/errors"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// AuditOutcomeDeleted marks a path that was deleted.
+	AuditOutcomeDeleted = "deleted"
+	// AuditOutcomeWouldDelete marks a path that would have been deleted in dry-run mode.
+	AuditOutcomeWouldDelete = "wouldDelete"
+	// AuditOutcomeQuarantined marks a file that was moved into the quarantine directory.
+	AuditOutcomeQuarantined = "quarantined"
+	// AuditOutcomeCompressed marks a file that was replaced by a compressed copy.
+	AuditOutcomeCompressed = "compressed"
+	// AuditOutcomeWouldCompress marks a file that would have been compressed in dry-run mode.
+	AuditOutcomeWouldCompress = "wouldCompress"
+	// AuditOutcomeFailed marks a path that could not be visited or deleted.
+	AuditOutcomeFailed = "failed"
+
+	// AuditReasonAge marks a path that was deleted because it was old enough.
+	AuditReasonAge = "age"
+	// AuditReasonSizeBudget marks a file that was deleted to meet the size budget, or a directory that was left empty by
+	// such deletions.
+	AuditReasonSizeBudget = "sizeBudget"
+)
+
+// AuditRecord describes what happened to a single path during a deletion run.
+type AuditRecord struct {
+	Time      time.Time  `json:"time"`
+	Path      string     `json:"path"`
+	Directory bool       `json:"directory"`
+	Size      int64      `json:"size"`
+	ModTime   *time.Time `json:"mtime,omitempty"`
+	// Owner contains the name of the owning user or the numeric user ID if the name cannot be resolved.
+	Owner   string `json:"owner,omitempty"`
+	Outcome string `json:"outcome"`
+	// Reason tells why a path was deleted or compressed. It is empty for failed paths.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// SHA256 contains the hex-encoded checksum of a deleted file's content if checksums are enabled.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// auditLog appends one JSON line per deleted or failed path to a writer. It may be used by several goroutines at once.
+type auditLog struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	// owners caches the user names per user ID because their lookup may involve NSS or LDAP requests.
+	owners map[uint32]string
+	// writeFailed suppresses further warnings after the first write error.
+	writeFailed bool
+}
+
+func newAuditLog(writer io.Writer) *auditLog {
+	return &auditLog{writer: writer, owners: map[uint32]string{}}
+}
+
+// openAuditLog opens the given file for appending. The file is created if it does not exist.
+func openAuditLog(file string) (*os.File, error) {
+	auditFile, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, errors2.Wrapf(err, "could not open audit log %q", file)
+	}
+
+	return auditFile, nil
+}
+
+// record appends a record for the given path. The file info may be nil if the path could not be read. A nil audit log
+// ignores all records.
+func (a *auditLog) record(path string, info os.FileInfo, outcome, reason string, err error, checksum string) {
+	if a == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Time:    nowClock.Now(),
+		Path:    path,
+		Outcome: outcome,
+		Reason:  reason,
+		SHA256:  checksum,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if info != nil {
+		modTime := info.ModTime()
+		record.Directory = info.IsDir()
+		record.Size = info.Size()
+		record.ModTime = &modTime
+		record.Owner = a.owner(info)
+	}
+
+	line, marshalErr := json.Marshal(record)
+	if marshalErr == nil {
+		_, marshalErr = a.writer.Write(append(line, '\n'))
+	}
+	if marshalErr != nil && !a.writeFailed {
+		a.writeFailed = true
+		log.Warningf("could not write audit log: %v", marshalErr)
+	}
+}
+
+// recordFailure appends a record for a path that could not be visited or deleted. The path is read again to describe
+// it as far as it still exists.
+func (a *auditLog) recordFailure(path string, err error) {
+	if a == nil {
+		return
+	}
+
+	info, _ := os.Lstat(path)
+	a.record(path, info, AuditOutcomeFailed, "", err, "")
+}
+
+// owner returns the name of the user owning the given file. The caller must hold the mutex.
+func (a *auditLog) owner(info os.FileInfo) string {
+	uid, ok := fileOwnerID(info)
+	if !ok {
+		return ""
+	}
+
+	name, cached := a.owners[uid]
+	if cached {
+		return name
+	}
+
+	name = strconv.FormatUint(uint64(uid), 10)
+	if owner, err := user.LookupId(name); err == nil {
+		name = owner.Username
+	}
+	a.owners[uid] = name
+
+	return name
+}
+
+// fileChecksum returns the hex-encoded SHA-256 checksum of the given file's content.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
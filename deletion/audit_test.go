@@ -0,0 +1,142 @@
+package deletion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_auditLog_record(t *testing.T) {
+	t.Run("should write one line per record", func(t *testing.T) {
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		fileTime := time.Date(2021, 3, 3, 3, 3, 0, 0, time.UTC)
+		file := createFileWithSizeAndTime(t, dir, "a-", 42, fileTime)
+		info, _ := os.Lstat(file)
+		buffer := &bytes.Buffer{}
+		sut := newAuditLog(buffer)
+
+		// when
+		sut.record(file, info, AuditOutcomeDeleted, AuditReasonAge, nil, "abc")
+		sut.recordFailure(filepath.Join(dir, "missing"), os.ErrPermission)
+
+		// then
+		records := readAuditRecords(t, buffer.String())
+		require.Len(t, records, 2)
+		assert.Equal(t, file, records[0].Path)
+		assert.False(t, records[0].Directory)
+		assert.Equal(t, int64(42), records[0].Size)
+		require.NotNil(t, records[0].ModTime)
+		assert.True(t, fileTime.Equal(*records[0].ModTime))
+		assert.NotEmpty(t, records[0].Owner)
+		assert.Equal(t, AuditOutcomeDeleted, records[0].Outcome)
+		assert.Equal(t, AuditReasonAge, records[0].Reason)
+		assert.Equal(t, "abc", records[0].SHA256)
+		assert.Empty(t, records[0].Error)
+
+		assert.Equal(t, filepath.Join(dir, "missing"), records[1].Path)
+		assert.Nil(t, records[1].ModTime)
+		assert.Equal(t, AuditOutcomeFailed, records[1].Outcome)
+		assert.Equal(t, os.ErrPermission.Error(), records[1].Error)
+	})
+	t.Run("should ignore records without audit log", func(t *testing.T) {
+		var sut *auditLog
+
+		assert.NotPanics(t, func() {
+			sut.record("/tmp/a", nil, AuditOutcomeDeleted, AuditReasonAge, nil, "")
+			sut.recordFailure("/tmp/a", os.ErrPermission)
+		})
+	})
+}
+
+func Test_fileChecksum(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+	defer func() { _ = os.RemoveAll(dir) }()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, []byte("hello"), 0644))
+
+	// when
+	actual, err := fileChecksum(file)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", actual)
+}
+
+func Test_deleter_Execute_withAuditLog(t *testing.T) {
+	t.Run("should record deleted and failed paths with checksums", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		auditDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-audit-")
+		defer func() { _ = os.RemoveAll(auditDir) }()
+		auditFile := filepath.Join(auditDir, "audit.jsonl")
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		deleteFile1 := createFileWithTime(t, startDir, "a-del-file", oldTime)
+		failFile1 := createFileWithTime(t, startDir, "b-fail-file", oldTime)
+		createFileWithTime(t, startDir, "c-stay-file", nowClock.Now())
+
+		remover = &failingFileRemover{failingPaths: map[string]bool{failFile1: true}}
+		defer func() { remover = &realFileRemover{} }()
+
+		args := Args{Directory: startDir, MaxAge: testMaxAge, MaxErrors: -1, AuditLogFile: auditFile, AuditChecksums: true}
+
+		// when
+		for i := 0; i < 2; i++ {
+			sut, _ := New(args)
+			_, err := sut.Execute(context.Background())
+			require.NoError(t, err)
+		}
+
+		// then
+		content, err := ioutil.ReadFile(auditFile)
+		require.NoError(t, err)
+		records := readAuditRecords(t, string(content))
+		// the failed file is recorded again in the second run
+		require.Len(t, records, 3)
+		assert.Equal(t, deleteFile1, records[0].Path)
+		assert.Equal(t, AuditOutcomeDeleted, records[0].Outcome)
+		// checksum of an empty file
+		assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", records[0].SHA256)
+		assert.Equal(t, failFile1, records[1].Path)
+		assert.Equal(t, AuditOutcomeFailed, records[1].Outcome)
+		assert.Equal(t, failFile1, records[2].Path)
+	})
+	t.Run("should not delete anything if the audit log cannot be opened", func(t *testing.T) {
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		deleteFile1 := createFileWithTime(t, startDir, "a-del-file", nowClock.Now().Add(-20*time.Hour))
+
+		auditFile := filepath.Join(startDir, "missing", "audit.jsonl")
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, AuditLogFile: auditFile})
+
+		// when
+		_, err := sut.Execute(context.Background())
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not open audit log")
+		assertFileExists(t, deleteFile1)
+	})
+}
+
+func readAuditRecords(t *testing.T, content string) []AuditRecord {
+	t.Helper()
+
+	var records []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		record := AuditRecord{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+
+	return records
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package deletion
+
+import (
+	"os"
+	"syscall"
+)
+
+func fileOwnerID(info os.FileInfo) (uint32, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return stat.Uid, true
+}
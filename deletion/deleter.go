@@ -1,13 +1,16 @@
 package deletion
 
 import (
+	"context"
 	"errors"
-	"github.com/hashicorp/go-multierror"
 	"github.com/op/go-logging"
 	errors2 "github.com/pkg/errors"
-	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -21,8 +24,64 @@ var (
 type Args struct {
 	// Directory names the starting directory which the deleter will recursively inspect for old files.
 	Directory string
-	// MaxAgeInHours sets how old at least a file or directory must be before it will be selected for deletion.
-	MaxAgeInHours int
+	// MaxAge sets how old at least a file or directory must be before it will be selected for deletion.
+	MaxAge time.Duration
+	// Stages optionally replaces MaxAge for files with a lifecycle of actions ordered by ascending age, f. e.
+	// compressing files older than 2h and deleting files older than 7d. Empty directories are still deleted regardless
+	// of the stages.
+	Stages []Stage
+	// DryRun walks and evaluates the directory tree as usual but only reports what would be deleted instead of
+	// removing anything.
+	DryRun bool
+	// Include restricts the deletion to paths that match at least one of these glob patterns. The patterns are
+	// evaluated relative to Directory and support "**" for any number of directories. An empty list selects all paths.
+	Include []string
+	// Exclude protects paths that match at least one of these glob patterns from deletion. Excluded directories will
+	// not be walked at all.
+	Exclude []string
+	// MaxSizeInBytes sets a size budget for all selected files in Directory. If the files still exceed this budget
+	// after the age-based deletion, the oldest files will be deleted until the budget is met. Zero disables the budget.
+	MaxSizeInBytes int64
+	// MinDirectoryAge sets how long an empty directory must have stayed unchanged before it will be deleted. The age
+	// is determined by the newest of the directory's modification and change time, which are updated whenever an
+	// entry is created or deleted inside. Zero deletes empty directories immediately.
+	MinDirectoryAge time.Duration
+	// KeepEmptyDirectories protects empty directories from deletion, f. e. if an application expects them to exist.
+	KeepEmptyDirectories bool
+	// MaxErrors sets how many files and directories may fail to be visited or deleted before the run is aborted. A
+	// negative value tolerates any number of failures, zero aborts the run at the first failure.
+	MaxErrors int
+	// Workers sets how many goroutines walk subdirectories and delete their entries in parallel. Values below 2 walk
+	// the start directory sequentially.
+	Workers int
+	// Policy optionally assigns their own deletion settings to subdirectories of Directory.
+	Policy *Policy
+	// SkipOpenFiles protects files that are currently held open by any process. Only processes that are visible in
+	// /proc are taken into account.
+	SkipOpenFiles bool
+	// TimeSource selects the file timestamp that determines the age of a file. Empty selects the modification time.
+	TimeSource TimeSource
+	// AuditLogFile names an optional file to which every deleted and failed path is appended as one line of JSON. The
+	// file is opened anew for each deletion run so that it can be rotated between runs.
+	AuditLogFile string
+	// QuarantineDirectory names an optional directory into which files are moved instead of being deleted. Each run
+	// gets its own subdirectory with a manifest of the original locations so that the files can be restored. The
+	// directory must be on the same filesystem as Directory and must not lie below it.
+	QuarantineDirectory string
+	// QuarantineRetention sets how long quarantined runs are kept before they are deleted for good.
+	QuarantineRetention time.Duration
+	// ArchiveDirectory names an optional directory in which the selected files of each run are packed into a
+	// compressed bundle before they are deleted. Nothing is deleted if the bundle cannot be written. The directory must
+	// not lie below Directory.
+	ArchiveDirectory string
+	// ArchiveFormat selects the compression of the bundles. Empty selects tar.gz.
+	ArchiveFormat ArchiveFormat
+	// ArchiveKeep sets how many bundles are kept in ArchiveDirectory. Older bundles are deleted after a new bundle was
+	// written. Zero keeps all bundles.
+	ArchiveKeep int
+	// AuditChecksums adds the SHA-256 checksum of each deleted file's content to the audit log. Every file is read
+	// completely before its deletion.
+	AuditChecksums bool
 }
 
 type clock interface {
@@ -48,116 +107,559 @@ func (r *realFileRemover) Remove(path string) error {
 type deleter struct {
 	Args
 	Results *Results
+	include patterns
+	exclude patterns
+	// rules contains the effective settings per subdirectory. The first rule belongs to the start directory.
+	rules []*compiledRule
+	// openFiles is built lazily once per deletion run if open files should be skipped.
+	openFiles     openFileIndex
+	openFilesOnce sync.Once
+	// realDirectory contains the start directory with resolved symlinks to look up paths in openFiles.
+	realDirectory string
+	// workers limits the number of additional goroutines that walk subdirectories. It is nil for sequential walks.
+	workers chan struct{}
+	// budgetExceeded tells all walking goroutines to stop because too many paths failed.
+	budgetExceeded atomic.Bool
+	// quotaMutex guards the quota candidates against concurrent walks.
+	quotaMutex sync.Mutex
+	// quotaCandidates collects the files that remain after the walk if a size budget is set.
+	quotaCandidates []quotaCandidate
+	// quotaSize sums up the sizes of the quota candidates.
+	quotaSize int64
+	// quotaDirectories counts the remaining entries of directories that were only kept because they were not empty.
+	// The size budget may still empty them.
+	quotaDirectories map[string]int
+	// quarantine moves the files of the current run into the quarantine directory. It is nil if files are deleted.
+	quarantine *quarantineRemover
+	// archive collects the selected paths of the current run until they are packed into a bundle. It is nil if paths
+	// are deleted right away.
+	archive *archiver
 }
 
 func New(args Args) (*deleter, error) {
 	if args.Directory == "" {
 		return nil, errors.New("directory must not be empty")
 	}
-	if args.MaxAgeInHours < 0 {
+	if args.MaxAge < 0 {
 		return nil, errors.New("file age must zero or positive")
 	}
+	if args.MinDirectoryAge < 0 {
+		return nil, errors.New("directory age must be zero or positive")
+	}
+	if args.Workers < 0 {
+		return nil, errors.New("number of workers must be zero or positive")
+	}
+	if args.MaxSizeInBytes < 0 {
+		return nil, errors.New("max size must be zero or positive")
+	}
+	if args.AuditChecksums && args.AuditLogFile == "" {
+		return nil, errors.New("audit checksums require an audit log file")
+	}
+	if args.QuarantineRetention < 0 {
+		return nil, errors.New("quarantine retention must be zero or positive")
+	}
+	if args.QuarantineDirectory != "" && IsBelow(args.QuarantineDirectory, args.Directory) {
+		return nil, errors.New("quarantine directory must not lie below the start directory")
+	}
+	if args.ArchiveDirectory != "" && IsBelow(args.ArchiveDirectory, args.Directory) {
+		return nil, errors.New("archive directory must not lie below the start directory")
+	}
+	if args.ArchiveDirectory != "" && args.QuarantineDirectory != "" {
+		return nil, errors.New("archive and quarantine cannot be used together")
+	}
+	if args.ArchiveKeep < 0 {
+		return nil, errors.New("number of kept archives must be zero or positive")
+	}
+	archiveFormat, err := ParseArchiveFormat(string(args.ArchiveFormat))
+	if err != nil {
+		return nil, err
+	}
+	args.ArchiveFormat = archiveFormat
+	timeSource, err := ParseTimeSource(string(args.TimeSource))
+	if err != nil {
+		return nil, err
+	}
+	args.TimeSource = timeSource
+	if len(args.Stages) > 0 {
+		args.Stages, err = validateStages(args.Stages)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return &deleter{args, &Results{}}, nil
+	include, err := newPatterns(args.Include)
+	if err != nil {
+		return nil, errors2.Wrap(err, "include patterns are invalid")
+	}
+	exclude, err := newPatterns(args.Exclude)
+	if err != nil {
+		return nil, errors2.Wrap(err, "exclude patterns are invalid")
+	}
+	rules, err := compileRules(args, args.Policy)
+	if err != nil {
+		return nil, errors2.Wrap(err, "policy is invalid")
+	}
+
+	var workers chan struct{}
+	if args.Workers > 1 {
+		// the calling goroutine always takes part in the walk
+		workers = make(chan struct{}, args.Workers-1)
+	}
+
+	return &deleter{
+		Args: args,
+		Results: &Results{
+			directory:     args.Directory,
+			dryRun:        args.DryRun,
+			quotaEnabled:  args.MaxSizeInBytes > 0,
+			openFileCheck: args.SkipOpenFiles,
+			quarantined:   args.QuarantineDirectory != "",
+			compression:   hasCompressStage(rules),
+		},
+		include: include,
+		exclude: exclude,
+		rules:   rules,
+		workers: workers,
+	}, nil
 }
 
-func (d *deleter) Execute() (*Results, error) {
-	var err error
+// Execute deletes old files, empty directories and files exceeding the size budget. Paths that cannot be visited or
+// deleted are recorded in the results and do not stop the run unless the error budget is exceeded. If the given
+// context is cancelled, the run stops at the next entry and returns the results so far together with an error.
+func (d *deleter) Execute(ctx context.Context) (*Results, error) {
+	d.Results.start(time.Now())
+	defer func() { d.Results.finish(time.Now()) }()
+
+	if d.AuditLogFile != "" {
+		auditFile, err := openAuditLog(d.AuditLogFile)
+		if err != nil {
+			return d.Results, err
+		}
+		defer func() { _ = auditFile.Close() }()
+		d.Results.audit = newAuditLog(auditFile)
+	}
+
+	d.recordDiskSpace(&d.Results.diskSpaceBefore)
+	defer d.recordDiskSpace(&d.Results.diskSpaceAfter)
 
-	log.Debug("Start recursive file deletion")
-	fileErr := filepath.Walk(d.Directory, d.filterOldFiles)
-	if fileErr != nil {
-		err = multierror.Append(err, fileErr)
+	if d.QuarantineDirectory != "" {
+		if !d.DryRun {
+			purgeQuarantine(d.QuarantineDirectory, d.QuarantineRetention)
+		}
+		d.quarantine = newQuarantineRemover(d.QuarantineDirectory, d.Directory, nowClock.Now())
+		defer d.quarantine.close()
 	}
+	if d.ArchiveDirectory != "" && !d.DryRun {
+		d.archive = &archiver{}
+	}
+
+	log.Debug("Start recursive deletion")
+	_, err := d.walkDirectory(ctx, d.Directory)
+	if err == nil && d.MaxSizeInBytes > 0 {
+		log.Debug("Start size budget deletion")
+		err = d.enforceQuota(ctx)
+	}
+	if err == nil && ctx.Err() == nil && d.archive != nil {
+		log.Debug("Start archiving")
+		err = d.archiveAndDelete(ctx, nowClock.Now())
+	}
+	// an aborted run in archive mode deletes nothing
+	d.archive = nil
 
-	log.Debug("Start recursive directory deletion")
-	// delete old and empty directories because recursive directories are complicated during the first file walk
-	dirErr := filepath.Walk(d.Directory, d.filterOldDirectories)
-	if dirErr != nil {
-		err = multierror.Append(err, dirErr)
+	if ctx.Err() != nil {
+		d.Results.interrupt()
+		return d.Results, errors2.Wrap(ctx.Err(), "deletion run was interrupted")
 	}
 
 	return d.Results, err
 }
 
-func (d *deleter) filterOldFiles(path string, info os.FileInfo, err error) error {
+// recordDiskSpace stores the current free disk space of the start directory's filesystem. The disk space is only
+// informational, so errors do not stop the deletion.
+func (d *deleter) recordDiskSpace(target **DiskSpace) {
+	space, err := freeDiskSpace(d.Directory)
 	if err != nil {
-		return errors2.Wrapf(err, "error while visiting path %q", path)
+		log.Warningf("could not determine free disk space: %v", err)
+		return
 	}
 
-	if info.IsDir() {
+	*target = &space
+}
+
+// failPath records a failed path and returns an error if the error budget is exceeded afterwards. Otherwise, the
+// caller should go on with the next path.
+func (d *deleter) failPath(path string, err error) error {
+	failed := d.Results.fail(path, err)
+	if d.MaxErrors < 0 || failed <= d.MaxErrors {
 		return nil
 	}
 
-	if fileOlderThan(d.MaxAgeInHours, info.ModTime()) {
-		return d.deleteFile(path, info)
-	}
+	d.budgetExceeded.Store(true)
+	return errors2.Wrapf(err, "aborting after %d failed paths", failed)
+}
 
-	d.Results.skip(path)
+// visitFailed records an error that occurred while reading the given path. Walking goes on with the next path.
+func (d *deleter) visitFailed(path string, err error) error {
+	return d.failPath(path, errors2.Wrapf(err, "error while visiting path %q", path))
+}
 
-	return nil
+// directoryContents counts the entries of a directory after it was walked.
+type directoryContents struct {
+	// remaining counts the entries which are left in the directory, including those that could not be read.
+	remaining int
+	// removed counts the entries which were deleted or would have been deleted in dry-run mode.
+	removed int
 }
 
-func (d *deleter) filterOldDirectories(path string, info os.FileInfo, err error) error {
+// walkDirectory recursively visits the entries of the given directory in post-order: files are deleted once they
+// are old enough and subdirectories are deleted after their own entries if nothing is left in them. Subdirectories are
+// handed to another goroutine if a worker is available. The walk stops early if the context is cancelled.
+func (d *deleter) walkDirectory(ctx context.Context, path string) (directoryContents, error) {
+	var contents directoryContents
+	var walkErr error
+	var mutex sync.Mutex
+	var waitGroup sync.WaitGroup
+
+	count := func(removed bool, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if err != nil && walkErr == nil {
+			walkErr = err
+		}
+		if removed {
+			contents.removed++
+		} else {
+			contents.remaining++
+		}
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil && path == d.Directory {
+		// a missing or unmounted start directory is not a failed path but prevents the whole run
+		return contents, errors2.Wrapf(err, "could not read start directory %q", path)
+	}
 	if err != nil {
-		return errors2.Wrapf(err, "error while visiting path %q", path)
+		// the directory may still contain entries that could not be read
+		contents.remaining++
+		budgetErr := d.visitFailed(path, err)
+		if budgetErr != nil {
+			return contents, budgetErr
+		}
 	}
 
-	// do not count-in the start directory, not even into the skipping counter
-	if path == d.Directory {
-		return nil
+	for _, entry := range entries {
+		if d.budgetExceeded.Load() || ctx.Err() != nil {
+			break
+		}
+
+		entryPath := filepath.Join(path, entry.Name())
+		switch {
+		case entry.IsDir() && d.acquireWorker():
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				defer d.releaseWorker()
+				count(d.visitDirectory(ctx, entryPath, entry))
+			}()
+		case entry.IsDir():
+			count(d.visitDirectory(ctx, entryPath, entry))
+		default:
+			count(d.visitFile(entryPath, entry))
+		}
 	}
+	waitGroup.Wait()
 
-	if !info.IsDir() {
-		log.Debugf("walk directories: skip file %s", path)
-		return nil
+	if walkErr == nil && ctx.Err() != nil {
+		walkErr = ctx.Err()
 	}
+	return contents, walkErr
+}
 
-	empty, err := isDirectoryEmpty(path)
+// acquireWorker returns true if another goroutine may walk a subdirectory. It never blocks so that a goroutine whose
+// subdirectories exhaust the workers simply walks them itself.
+func (d *deleter) acquireWorker() bool {
+	select {
+	case d.workers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *deleter) releaseWorker() {
+	<-d.workers
+}
+
+// visitFile deletes or compresses the given file if it is selected and old enough for a stage. It returns true if the
+// file is gone afterwards.
+func (d *deleter) visitFile(path string, entry os.DirEntry) (bool, error) {
+	relPath := d.relativePath(path)
+	if !d.selected(relPath) {
+		d.Results.skip(path)
+		d.remainUnselected(entry)
+		return false, nil
+	}
+
+	info, err := entry.Info()
+	if os.IsNotExist(err) {
+		log.Debugf("vanished: %s", path)
+		return true, nil
+	}
 	if err != nil {
-		return errors2.Wrapf(err, "error while checking directory contents for path %q", path)
+		return false, d.visitFailed(path, err)
 	}
 
-	// deleting empty directories is simpler than checking the timestamp because the timestamp changes on every
-	// file deletion inside the directory
-	if empty {
-		return d.deleteFile(path, info)
+	switch d.ruleFor(relPath).actionFor(fileTime(d.TimeSource, path, info)) {
+	case StageActionDelete:
+		removed, err := d.deleteFile(path, info)
+		if !removed {
+			d.Results.remain(info.Size())
+		}
+		return removed, err
+	case StageActionCompress:
+		if compressible(path, info) {
+			// the compressed file remains in the directory, too
+			return false, d.compressFile(path, info)
+		}
 	}
+
 	d.Results.skip(path)
+	d.Results.remain(info.Size())
+	d.addQuotaCandidate(path, info)
+
+	return false, nil
+}
 
-	return nil
+// remainUnselected counts the size of a file that is not selected for deletion. The size is only informational, so
+// errors are ignored.
+func (d *deleter) remainUnselected(entry os.DirEntry) {
+	info, err := entry.Info()
+	if err != nil {
+		return
+	}
+
+	d.Results.remain(info.Size())
 }
 
-// taken from https://stackoverflow.com/a/30708914/12529534
-func isDirectoryEmpty(name string) (bool, error) {
-	f, err := os.Open(name)
+// visitDirectory walks the given directory and deletes it afterwards if it is selected, empty and old enough. It
+// returns true if the directory is gone afterwards.
+func (d *deleter) visitDirectory(ctx context.Context, path string, entry os.DirEntry) (bool, error) {
+	relPath := d.relativePath(path)
+	if d.excluded(relPath) {
+		log.Debugf("skip excluded directory %s", path)
+		d.Results.skip(path)
+		return false, nil
+	}
+
+	// always walk the directory because contained paths may still be selected
+	contents, err := d.walkDirectory(ctx, path)
 	if err != nil {
 		return false, err
 	}
-	defer func() { _ = f.Close() }()
 
-	const limitFileCount = 2 // we only want to know if the dir is empty and not how many objects there are
-	_, err = f.Readdir(limitFileCount)
-	if err == io.EOF {
+	rule := d.ruleFor(relPath)
+	if !d.selected(relPath) || !rule.deleteEmptyDirectories {
+		d.Results.skip(path)
+		return false, nil
+	}
+	if contents.remaining > 0 {
+		d.Results.skip(path)
+		d.addQuotaDirectory(path, contents.remaining)
+		return false, nil
+	}
+
+	// read the timestamps only now because the deletion of the contained entries has just changed them
+	info, err := entry.Info()
+	if os.IsNotExist(err) {
+		log.Debugf("vanished: %s", path)
 		return true, nil
 	}
+	if err != nil {
+		return false, d.visitFailed(path, err)
+	}
+
+	if !d.directoryOlderThan(rule.minDirectoryAge, path, info, contents.removed > 0) {
+		d.Results.skip(path)
+		return false, nil
+	}
 
-	return false, err
+	return d.deleteFile(path, info)
 }
 
-func (d *deleter) deleteFile(path string, info os.FileInfo) error {
-	err := remover.Remove(path)
+// directoryOlderThan returns true if the given directory has stayed unchanged for longer than the given age. In
+// dry-run and archive mode, a directory whose entries would have been deleted counts as just modified.
+func (d *deleter) directoryOlderThan(minAge time.Duration, path string, info os.FileInfo, entriesRemoved bool) bool {
+	if minAge == 0 {
+		return true
+	}
+	if (d.DryRun || d.archive != nil) && entriesRemoved {
+		return false
+	}
+
+	return fileOlderThan(minAge, directoryTime(path, info))
+}
+
+// IsBelow returns true if the given path equals the given directory or lies below it. Relative paths are resolved
+// against the working directory first.
+func IsBelow(path, directory string) bool {
+	absPath, err := filepath.Abs(path)
 	if err != nil {
-		d.Results.fail(path, err)
-	} else {
-		d.Results.pass(path, info)
+		return false
+	}
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return false
+	}
+
+	relPath, err := filepath.Rel(absDirectory, absPath)
+	return err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// relativePath returns the slash-separated path relative to the start directory which is used for pattern matching.
+func (d *deleter) relativePath(path string) string {
+	relPath, err := filepath.Rel(d.Directory, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+
+	return filepath.ToSlash(relPath)
+}
+
+// ruleFor returns the most specific rule that contains the given relative path.
+func (d *deleter) ruleFor(relPath string) *compiledRule {
+	mostSpecific := d.rules[0]
+	for _, rule := range d.rules[1:] {
+		if rule.contains(relPath) && len(rule.path) > len(mostSpecific.path) {
+			mostSpecific = rule
+		}
+	}
+
+	return mostSpecific
+}
+
+// excluded returns true if the given relative path matches the global exclude patterns or the exclude patterns of
+// its rule.
+func (d *deleter) excluded(relPath string) bool {
+	return d.exclude.matches(relPath) || d.ruleFor(relPath).excludes(relPath)
+}
+
+// selected returns true if the given relative path is neither excluded nor left out by the include patterns.
+func (d *deleter) selected(relPath string) bool {
+	if d.excluded(relPath) {
+		return false
+	}
+	if len(d.include) > 0 && !d.include.matchesPathOrParent(relPath) {
+		return false
+	}
+
+	return d.ruleFor(relPath).includes(relPath)
+}
+
+func (d *deleter) deleteFile(path string, info os.FileInfo) (bool, error) {
+	return d.removePath(path, info, d.Results.pass)
+}
+
+// removePath deletes the given path and counts a successful deletion with the given results function. It returns true
+// if the path was deleted or would have been deleted in dry-run mode.
+func (d *deleter) removePath(path string, info os.FileInfo,
+	pass func(path string, info os.FileInfo, checksum string)) (bool, error) {
+	if d.SkipOpenFiles && !info.IsDir() && d.isOpen(path) {
+		d.Results.skipInUse(path)
+		return false, nil
+	}
+
+	checksum := d.checksum(path, info)
+	if d.DryRun {
+		pass(path, info, checksum)
+		return true, nil
+	}
+	if d.archive != nil {
+		d.archive.add(path, info, pass, checksum)
+		return true, nil
+	}
+
+	return d.deletePath(path, info, pass, checksum)
+}
+
+// deletePath deletes the given path unless it is a file that changed since its inspection. A directory that is no
+// longer empty because entries were created in the meantime is skipped.
+func (d *deleter) deletePath(path string, info os.FileInfo, pass func(path string, info os.FileInfo, checksum string),
+	checksum string) (bool, error) {
+	if !info.IsDir() {
+		changed, err := fileChanged(path, info)
+		if err != nil {
+			return false, d.failPath(path, errors2.Wrapf(err, "error while re-checking path %q", path))
+		}
+		if changed {
+			d.Results.skipChanged(path)
+			return false, nil
+		}
+	}
+
+	err := d.fileRemover().Remove(path)
+	if info.IsDir() && errors.Is(err, syscall.ENOTEMPTY) {
+		d.Results.skip(path)
+		return false, nil
+	}
+	if err != nil {
+		return false, d.failPath(path, err)
+	}
+	pass(path, info, checksum)
+
+	return true, nil
+}
+
+// checksum returns the SHA-256 checksum of the given file for the audit log if checksums are enabled. A file that
+// cannot be read is deleted nevertheless because the checksum is only informational.
+func (d *deleter) checksum(path string, info os.FileInfo) string {
+	if !d.AuditChecksums || info.IsDir() {
+		return ""
+	}
+
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		log.Warningf("could not compute checksum of %s: %v", path, err)
+		return ""
+	}
+
+	return checksum
+}
+
+// fileRemover returns the quarantine of the current run if there is one, otherwise the regular remover.
+func (d *deleter) fileRemover() fileRemover {
+	if d.quarantine != nil {
+		return d.quarantine
+	}
+
+	return remover
+}
+
+// isOpen returns true if any process holds the given file open. The open files are determined only once per run.
+func (d *deleter) isOpen(path string) bool {
+	d.openFilesOnce.Do(func() {
+		d.openFiles = newOpenFileIndex(procDirectory)
+		d.realDirectory = realPath(d.Directory)
+	})
+
+	return d.openFiles.isOpen(filepath.Join(d.realDirectory, filepath.FromSlash(d.relativePath(path))))
+}
+
+// fileChanged checks whether the given file was replaced, modified or deleted since its file info was read. This
+// protects files that were rewritten right after their inspection, f. e. under a re-used temp file name.
+func fileChanged(path string, info os.FileInfo) (bool, error) {
+	current, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
-	return err
+	return !os.SameFile(info, current) || !current.ModTime().Equal(info.ModTime()) || current.Size() != info.Size(), nil
 }
 
-func fileOlderThan(maxAgeHours int, fileTime time.Time) bool {
-	ageCutOff := time.Duration(maxAgeHours) * time.Hour
+func fileOlderThan(ageCutOff time.Duration, fileTime time.Time) bool {
 	now := nowClock.Now()
 
 	diff := now.Sub(fileTime)
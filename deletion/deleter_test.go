@@ -1,25 +1,27 @@
 package deletion
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
-const testMaxAgeInHours = 12
+const testMaxAge = 12 * time.Hour
 
 func TestNew(t *testing.T) {
 	t.Run("should set args", func(t *testing.T) {
-		input := Args{Directory: "/test", MaxAgeInHours: 42}
+		input := Args{Directory: "/test", MaxAge: 42 * time.Hour}
 
 		sut, _ := New(input)
 
 		assert.Equal(t, "/test", sut.Directory)
-		assert.Equal(t, 42, sut.MaxAgeInHours)
+		assert.Equal(t, 42*time.Hour, sut.MaxAge)
 	})
 
 	type args struct {
@@ -31,11 +33,24 @@ func TestNew(t *testing.T) {
 		wantDeleter bool
 		wantErr     bool
 	}{
-		{"should pass", args{Args{Directory: "/test", MaxAgeInHours: 12}}, true, false},
-		{"should pass with 0 age", args{Args{Directory: "/test", MaxAgeInHours: 0}}, true, false},
-		{"should fail with invalid directory", args{Args{Directory: "", MaxAgeInHours: 12}}, false, true},
-		{"should fail with invalid age", args{Args{Directory: "/a", MaxAgeInHours: -1}}, false, true},
-		{"should fail with invalid directory and age", args{Args{Directory: "", MaxAgeInHours: -1}}, false, true},
+		{"should pass", args{Args{Directory: "/test", MaxAge: 12 * time.Hour}}, true, false},
+		{"should pass with 0 age", args{Args{Directory: "/test", MaxAge: 0}}, true, false},
+		{"should fail with invalid directory", args{Args{Directory: "", MaxAge: 12 * time.Hour}}, false, true},
+		{"should fail with invalid age", args{Args{Directory: "/a", MaxAge: -1}}, false, true},
+		{"should fail with invalid directory and age", args{Args{Directory: "", MaxAge: -1}}, false, true},
+		{"should fail with invalid directory age", args{Args{Directory: "/a", MinDirectoryAge: -1}}, false, true},
+		{"should fail with invalid number of workers", args{Args{Directory: "/a", Workers: -1}}, false, true},
+		{"should fail with invalid max size", args{Args{Directory: "/a", MaxSizeInBytes: -1}}, false, true},
+		{"should fail with invalid time source", args{Args{Directory: "/a", TimeSource: "yesterday"}}, false, true},
+		{"should fail with invalid include pattern", args{Args{Directory: "/a", Include: []string{"[a"}}}, false, true},
+		{"should fail with invalid exclude pattern", args{Args{Directory: "/a", Exclude: []string{"[a"}}}, false, true},
+		{"should fail with audit checksums without audit log", args{Args{Directory: "/a", AuditChecksums: true}}, false, true},
+		{"should fail with quarantine below the start directory", args{Args{Directory: "/a", QuarantineDirectory: "/a/trash"}}, false, true},
+		{"should fail with invalid quarantine retention", args{Args{Directory: "/a", QuarantineRetention: -1}}, false, true},
+		{"should fail with archive below the start directory", args{Args{Directory: "/a", ArchiveDirectory: "/a/archive"}}, false, true},
+		{"should fail with archive and quarantine", args{Args{Directory: "/a", ArchiveDirectory: "/b", QuarantineDirectory: "/c"}}, false, true},
+		{"should fail with invalid number of kept archives", args{Args{Directory: "/a", ArchiveKeep: -1}}, false, true},
+		{"should fail with invalid archive format", args{Args{Directory: "/a", ArchiveFormat: "zip"}}, false, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -62,14 +77,15 @@ func Test_deleter_deleteFile(t *testing.T) {
 		file := createFileWithTime(t, dir, "", oldness)
 		fileInfo, _ := os.Stat(file)
 
-		sut, _ := New(Args{Directory: dir, MaxAgeInHours: testMaxAgeInHours})
-		assert.Empty(t, sut.Results)
+		sut, _ := New(Args{Directory: dir, MaxAge: testMaxAge})
+		assert.Equal(t, &Results{directory: dir}, sut.Results)
 
 		// when
-		err := sut.deleteFile(file, fileInfo)
+		removed, err := sut.deleteFile(file, fileInfo)
 
 		// then
 		require.NoError(t, err)
+		assert.True(t, removed)
 		assert.Equal(t, 1, sut.Results.deleted)
 		assert.Equal(t, 0, sut.Results.failed)
 		assert.Equal(t, 0, sut.Results.skipped)
@@ -77,16 +93,19 @@ func Test_deleter_deleteFile(t *testing.T) {
 
 	t.Run("should error on file error and update stats", func(t *testing.T) {
 		// given
-		const path = "/some/error/will/occur/here"
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		path := createFileWithTime(t, dir, "", nowClock.Now().Add(-20*time.Hour))
+		info, _ := os.Lstat(path)
 		removerMock := &mockFileRemover{}
 		removerMock.On("Remove", path).Return(os.ErrNotExist)
 		remover = removerMock
 		defer func() { remover = &realFileRemover{} }()
 
-		sut, _ := New(Args{Directory: "dir", MaxAgeInHours: testMaxAgeInHours})
+		sut, _ := New(Args{Directory: "dir", MaxAge: testMaxAge})
 
 		// when
-		err := sut.deleteFile(path, nil)
+		_, err := sut.deleteFile(path, info)
 
 		// then
 		require.Error(t, err)
@@ -96,6 +115,100 @@ func Test_deleter_deleteFile(t *testing.T) {
 		assert.Equal(t, 0, sut.Results.skipped)
 		(remover).(*mockFileRemover).AssertExpectations(t)
 	})
+
+	t.Run("should record file error and go on within the error budget", func(t *testing.T) {
+		// given
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		path := createFileWithTime(t, dir, "", nowClock.Now().Add(-20*time.Hour))
+		info, _ := os.Lstat(path)
+		removerMock := &mockFileRemover{}
+		removerMock.On("Remove", path).Return(os.ErrPermission)
+		remover = removerMock
+		defer func() { remover = &realFileRemover{} }()
+
+		sut, _ := New(Args{Directory: "dir", MaxAge: testMaxAge, MaxErrors: -1})
+
+		// when
+		removed, err := sut.deleteFile(path, info)
+
+		// then
+		require.NoError(t, err)
+		assert.False(t, removed)
+		assert.Equal(t, 1, sut.Results.failed)
+		require.Len(t, sut.Results.Failures(), 1)
+		assert.Equal(t, path, sut.Results.Failures()[0].Path)
+		assert.Equal(t, os.ErrPermission, sut.Results.Failures()[0].Err)
+		(remover).(*mockFileRemover).AssertExpectations(t)
+	})
+
+	t.Run("should not delete a file that changed since its inspection", func(t *testing.T) {
+		// given
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		path := createFileWithTime(t, dir, "", nowClock.Now().Add(-20*time.Hour))
+		info, _ := os.Lstat(path)
+		writeBytesToFile(t, path, 10)
+
+		sut, _ := New(Args{Directory: dir, MaxAge: testMaxAge})
+
+		// when
+		removed, err := sut.deleteFile(path, info)
+
+		// then
+		require.NoError(t, err)
+		assert.False(t, removed)
+		assert.Equal(t, 0, sut.Results.deleted)
+		assert.Equal(t, 1, sut.Results.changed)
+		assertFileExists(t, path)
+	})
+}
+
+func Test_fileChanged(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+	defer func() { _ = os.RemoveAll(dir) }()
+	oldTime := time.Now().Add(-20 * time.Hour)
+
+	t.Run("should return false for an unchanged file", func(t *testing.T) {
+		path := createFileWithTime(t, dir, "unchanged", oldTime)
+		info, _ := os.Lstat(path)
+
+		actual, err := fileChanged(path, info)
+
+		require.NoError(t, err)
+		assert.False(t, actual)
+	})
+	t.Run("should return true for a modified file", func(t *testing.T) {
+		path := createFileWithTime(t, dir, "modified", oldTime)
+		info, _ := os.Lstat(path)
+		require.NoError(t, os.Chtimes(path, time.Now(), time.Now()))
+
+		actual, err := fileChanged(path, info)
+
+		require.NoError(t, err)
+		assert.True(t, actual)
+	})
+	t.Run("should return true for a replaced file with the same timestamps and size", func(t *testing.T) {
+		path := createFileWithTime(t, dir, "replaced", oldTime)
+		info, _ := os.Lstat(path)
+		replacement := createFileWithTime(t, dir, "replacement", oldTime)
+		require.NoError(t, os.Rename(replacement, path))
+
+		actual, err := fileChanged(path, info)
+
+		require.NoError(t, err)
+		assert.True(t, actual)
+	})
+	t.Run("should return true for a vanished file", func(t *testing.T) {
+		path := createFileWithTime(t, dir, "vanished", oldTime)
+		info, _ := os.Lstat(path)
+		require.NoError(t, os.Remove(path))
+
+		actual, err := fileChanged(path, info)
+
+		require.NoError(t, err)
+		assert.True(t, actual)
+	})
 }
 
 func Test_fileOlderThan(t *testing.T) {
@@ -105,7 +218,7 @@ func Test_fileOlderThan(t *testing.T) {
 		theBeginningOfComputing := time.Unix(0, 0)
 
 		// when
-		actual := fileOlderThan(testMaxAgeInHours, theBeginningOfComputing)
+		actual := fileOlderThan(testMaxAge, theBeginningOfComputing)
 
 		// then
 		assert.True(t, actual)
@@ -114,7 +227,7 @@ func Test_fileOlderThan(t *testing.T) {
 		minus12HoursTime := nowClock.Now().Add(-12 * time.Hour).Add(-1 * time.Second)
 
 		// when
-		actual := fileOlderThan(testMaxAgeInHours, minus12HoursTime)
+		actual := fileOlderThan(testMaxAge, minus12HoursTime)
 
 		// then
 		assert.True(t, actual)
@@ -123,7 +236,7 @@ func Test_fileOlderThan(t *testing.T) {
 		minus12HoursTime := nowClock.Now().Add(-12 * time.Hour)
 
 		// when
-		actual := fileOlderThan(testMaxAgeInHours, minus12HoursTime)
+		actual := fileOlderThan(testMaxAge, minus12HoursTime)
 
 		// then
 		assert.False(t, actual)
@@ -132,7 +245,7 @@ func Test_fileOlderThan(t *testing.T) {
 		minus11Hours59SecTime := nowClock.Now().Add(-11 * time.Hour).Add(-59 * time.Second)
 
 		// when
-		actual := fileOlderThan(testMaxAgeInHours, minus11Hours59SecTime)
+		actual := fileOlderThan(testMaxAge, minus11Hours59SecTime)
 
 		// then
 		assert.False(t, actual)
@@ -141,14 +254,14 @@ func Test_fileOlderThan(t *testing.T) {
 		minus11HoursTime := nowClock.Now().Add(-11 * time.Hour)
 
 		// when
-		actual := fileOlderThan(testMaxAgeInHours, minus11HoursTime)
+		actual := fileOlderThan(testMaxAge, minus11HoursTime)
 
 		// then
 		assert.False(t, actual)
 	})
 	t.Run("should return false for 0 hours old files", func(t *testing.T) {
 		// when
-		actual := fileOlderThan(testMaxAgeInHours, nowClock.Now())
+		actual := fileOlderThan(testMaxAge, nowClock.Now())
 
 		// then
 		assert.False(t, actual)
@@ -157,28 +270,53 @@ func Test_fileOlderThan(t *testing.T) {
 		theFuture := nowClock.Now().Add(24 * 365 * time.Hour)
 
 		// when
-		actual := fileOlderThan(testMaxAgeInHours, theFuture)
+		actual := fileOlderThan(testMaxAge, theFuture)
 
 		// then
 		assert.False(t, actual)
 	})
 }
 
-func Test_deleter_filterOldFiles(t *testing.T) {
-	t.Run("should skip directories", func(t *testing.T) {
+func Test_deleter_walkDirectory(t *testing.T) {
+	t.Run("should count remaining and removed entries", func(t *testing.T) {
+		// given
+		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(dir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		newTime := nowClock.Now().Add(-2 * time.Hour)
+		_ = createFileWithTime(t, dir, "a-del-file", oldTime)
+		_ = createFileWithTime(t, dir, "b-stay-file", newTime)
+		innerDir, _ := ioutil.TempDir(dir, "c-del-dir-")
+		_ = createFileWithTime(t, innerDir, "a-del-file", oldTime)
+
+		sut, _ := New(Args{Directory: dir, MaxAge: testMaxAge})
+
+		// when
+		actual, err := sut.walkDirectory(context.Background(), dir)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, directoryContents{remaining: 1, removed: 2}, actual)
+		assertFileNotExists(t, innerDir)
+	})
+	t.Run("should delete nested empty directories in a single walk", func(t *testing.T) {
+		// given
 		dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
 		defer func() { _ = os.RemoveAll(dir) }()
-		innerDir, _ := ioutil.TempDir(dir, "tempdel-")
+		nestedDir := filepath.Join(dir, "a", "b", "c")
+		_ = os.MkdirAll(nestedDir, 0755)
 
-		sut, _ := New(Args{Directory: dir, MaxAgeInHours: testMaxAgeInHours})
-		innerDirStats, _ := os.Stat(innerDir)
+		sut, _ := New(Args{Directory: dir, MaxAge: testMaxAge})
 
 		// when
-		err := sut.filterOldFiles(innerDir, innerDirStats, nil)
+		actual, err := sut.walkDirectory(context.Background(), dir)
 
+		// then
 		require.NoError(t, err)
-		_, err = os.Stat(innerDir)
-		assert.NoError(t, err)
+		assert.Equal(t, directoryContents{remaining: 0, removed: 1}, actual)
+		assert.Equal(t, 3, sut.Results.deleted)
+		assertFileNotExists(t, filepath.Join(dir, "a"))
+		assertFileExists(t, dir)
 	})
 }
 
@@ -188,17 +326,17 @@ func Test_deleter_Execute(t *testing.T) {
 		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
 		defer func() { _ = os.RemoveAll(startDir) }()
 		// Name files ABC... because fileWalk iterates files alphabetically
-		oldTime := nowClock.Now().Add(-testMaxAgeInHours - 20*time.Hour)
+		oldTime := nowClock.Now().Add(-testMaxAge - 20*time.Hour)
 		newTime := nowClock.Now().Add(-2 * time.Hour)
 		deleteFile1 := createFileWithTime(t, startDir, "a-", oldTime)
 		leaveFile1 := createFileWithTime(t, startDir, "b-", newTime)
 		deleteFile2 := createFileWithTime(t, startDir, "c-", oldTime)
 		leaveFile2 := createFileWithTime(t, startDir, "d-", newTime)
 
-		sut, _ := New(Args{Directory: startDir, MaxAgeInHours: testMaxAgeInHours})
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge})
 
 		// when
-		actual, err := sut.Execute()
+		actual, err := sut.Execute(context.Background())
 
 		// then
 		require.NoError(t, err)
@@ -227,10 +365,10 @@ func Test_deleter_Execute(t *testing.T) {
 		deleteFile3 := createFileWithTime(t, startDir, "c-del-file", oldTime)
 		leaveFile2 := createFileWithTime(t, startDir, "d-stay", newTime)
 
-		sut, _ := New(Args{Directory: startDir, MaxAgeInHours: testMaxAgeInHours})
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge})
 
 		// when
-		actual, err := sut.Execute()
+		actual, err := sut.Execute(context.Background())
 
 		// then
 		require.NoError(t, err)
@@ -260,10 +398,10 @@ func Test_deleter_Execute(t *testing.T) {
 		deleteFile3 := createFileWithTime(t, startDir, "c-del-file", oldTime)
 		leaveFile2 := createFileWithTime(t, startDir, "d-stay", newTime)
 
-		sut, _ := New(Args{Directory: startDir, MaxAgeInHours: testMaxAgeInHours})
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge})
 
 		// when
-		actual, err := sut.Execute()
+		actual, err := sut.Execute(context.Background())
 
 		// then
 		require.NoError(t, err)
@@ -276,6 +414,373 @@ func Test_deleter_Execute(t *testing.T) {
 		assertFileExists(t, leaveFile1)
 		assertFileExists(t, leaveFile2)
 	})
+	t.Run("should only count files and directories that would be deleted in dry-run mode", func(t *testing.T) {
+		// given
+		realStdout := os.Stdout
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		// Name files ABC... because fileWalk iterates files alphabetically
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		newTime := nowClock.Now().Add(-2 * time.Hour)
+
+		wouldDeleteDir1, _ := ioutil.TempDir(startDir, "a-del-dir-")
+		wouldDeleteFile2 := createFileWithTime(t, wouldDeleteDir1, "a-del-file", oldTime)
+		leaveFile1 := createFileWithTime(t, startDir, "b-stay-file", newTime)
+		wouldDeleteFile3 := createFileWithTime(t, startDir, "c-del-file", oldTime)
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, DryRun: true})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 3, actual.deleted)
+		assert.Equal(t, 0, actual.failed)
+		assert.Equal(t, 1, actual.skipped)
+		assertFileExists(t, wouldDeleteDir1)
+		assertFileExists(t, wouldDeleteFile2)
+		assertFileExists(t, wouldDeleteFile3)
+		assertFileExists(t, leaveFile1)
+
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Contains(t, actualOutput, "[tempdel] would delete: "+wouldDeleteFile2+" (0 bytes, modified ")
+		assert.Contains(t, actualOutput, "[tempdel] would delete: "+wouldDeleteFile3+" (0 bytes, modified ")
+		assert.Contains(t, actualOutput, "[tempdel] would delete: "+wouldDeleteDir1+" (")
+	})
+	t.Run("should keep empty directories that are younger than the min directory age", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+
+		// the deletion of the contained file renews the directory's timestamps
+		leaveDir1, _ := ioutil.TempDir(startDir, "a-stay-dir-")
+		_ = os.Chtimes(leaveDir1, oldTime, oldTime)
+		deleteFile1 := createFileWithTime(t, leaveDir1, "a-del-file", oldTime)
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MinDirectoryAge: time.Hour})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.deleted)
+		assert.Equal(t, 1, actual.skipped)
+		assertFileNotExists(t, deleteFile1)
+		assertFileExists(t, leaveDir1)
+	})
+	t.Run("should delete empty directories that are older than the min directory age", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		deleteDir1, _ := ioutil.TempDir(startDir, "a-del-dir-")
+
+		// the change time cannot be set, so the clock is moved forward instead
+		originalClock := nowClock
+		defer func() { nowClock = originalClock }()
+		nowClock = &testClock{time.Now().Add(2 * time.Hour)}
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MinDirectoryAge: time.Hour})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.deleted)
+		assert.Equal(t, 0, actual.skipped)
+		assertFileNotExists(t, deleteDir1)
+	})
+	t.Run("should keep directories with would-be-deleted files in dry-run mode with min directory age", func(t *testing.T) {
+		// given
+		realStdout := os.Stdout
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		leaveDir1, _ := ioutil.TempDir(startDir, "a-stay-dir-")
+
+		originalClock := nowClock
+		defer func() { nowClock = originalClock }()
+		nowClock = &testClock{time.Now().Add(2 * time.Hour)}
+		wouldDeleteFile1 := createFileWithTime(t, leaveDir1, "a-del-file", nowClock.Now().Add(-20*time.Hour))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MinDirectoryAge: time.Hour, DryRun: true})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.deleted)
+		assert.Equal(t, 1, actual.skipped)
+
+		actualOutput := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Contains(t, actualOutput, "[tempdel] would delete: "+wouldDeleteFile1+" (")
+		assert.NotContains(t, actualOutput, "[tempdel] would delete: "+leaveDir1+" (")
+	})
+	t.Run("should go on after failed deletions", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		leaveDir1, _ := ioutil.TempDir(startDir, "a-stay-dir-")
+		failFile1 := createFileWithTime(t, leaveDir1, "a-fail-file", oldTime)
+		deleteFile1 := createFileWithTime(t, startDir, "b-del-file", oldTime)
+		failFile2 := createFileWithTime(t, startDir, "c-fail-file", oldTime)
+
+		remover = &failingFileRemover{failingPaths: map[string]bool{failFile1: true, failFile2: true}}
+		defer func() { remover = &realFileRemover{} }()
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MaxErrors: -1})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.deleted)
+		assert.Equal(t, 2, actual.failed)
+		assert.Equal(t, 1, actual.skipped)
+		require.Len(t, actual.Failures(), 2)
+		assert.Equal(t, failFile1, actual.Failures()[0].Path)
+		assert.Equal(t, failFile2, actual.Failures()[1].Path)
+		assertFileNotExists(t, deleteFile1)
+		assertFileExists(t, leaveDir1)
+	})
+	t.Run("should fail if the start directory cannot be read", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		_ = os.RemoveAll(startDir)
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MaxErrors: -1})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not read start directory")
+		assert.Equal(t, 0, actual.failed)
+	})
+	t.Run("should abort when the error budget is exceeded", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		failFile1 := createFileWithTime(t, startDir, "a-fail-file", oldTime)
+		failFile2 := createFileWithTime(t, startDir, "b-fail-file", oldTime)
+		leaveFile1 := createFileWithTime(t, startDir, "c-stay-file", oldTime)
+
+		remover = &failingFileRemover{failingPaths: map[string]bool{failFile1: true, failFile2: true}}
+		defer func() { remover = &realFileRemover{} }()
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MaxErrors: 1})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "aborting after 2 failed paths")
+		assert.Equal(t, 2, actual.failed)
+		assert.Equal(t, 0, actual.deleted)
+		assertFileExists(t, leaveFile1)
+	})
+	t.Run("should delete the same paths with several workers", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		newTime := nowClock.Now().Add(-2 * time.Hour)
+
+		var deleteDirs, leaveFiles []string
+		for i := 0; i < 10; i++ {
+			deleteDir, _ := ioutil.TempDir(startDir, "a-del-dir-")
+			innerDir, _ := ioutil.TempDir(deleteDir, "a-del-dir-")
+			_ = createFileWithTime(t, innerDir, "a-del-file", oldTime)
+			_ = createFileWithTime(t, deleteDir, "b-del-file", oldTime)
+			deleteDirs = append(deleteDirs, deleteDir)
+
+			leaveDir, _ := ioutil.TempDir(startDir, "b-stay-dir-")
+			leaveFiles = append(leaveFiles, createFileWithTime(t, leaveDir, "a-stay-file", newTime))
+		}
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, Workers: 4})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 40, actual.deleted)
+		assert.Equal(t, 0, actual.failed)
+		assert.Equal(t, 20, actual.skipped)
+		for i := range deleteDirs {
+			assertFileNotExists(t, deleteDirs[i])
+			assertFileExists(t, leaveFiles[i])
+		}
+	})
+	t.Run("should stop at the next entry when the context is cancelled", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		deleteFile1 := createFileWithTime(t, startDir, "a-del-file", oldTime)
+		leaveFile1 := createFileWithTime(t, startDir, "b-stay-file", oldTime)
+		leaveFile2 := createFileWithTime(t, startDir, "c-stay-file", oldTime)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		remover = &cancellingFileRemover{cancel: cancel}
+		defer func() { remover = &realFileRemover{} }()
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge})
+
+		// when
+		actual, err := sut.Execute(ctx)
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "deletion run was interrupted")
+		assert.True(t, actual.Interrupted())
+		assert.Equal(t, 1, actual.deleted)
+		assertFileNotExists(t, deleteFile1)
+		assertFileExists(t, leaveFile1)
+		assertFileExists(t, leaveFile2)
+	})
+	t.Run("should not walk excluded directories", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+
+		leaveDir1 := filepath.Join(startDir, "lucene")
+		_ = os.MkdirAll(filepath.Join(leaveDir1, "empty"), 0755)
+		leaveFile1 := createFileWithTime(t, leaveDir1, "a-stay-file", oldTime)
+		leaveFile2 := createFileWithTime(t, startDir, "b-stay-file.lock", oldTime)
+		deleteFile1 := createFileWithTime(t, startDir, "c-del-file", oldTime)
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, Exclude: []string{"**/lucene", "*.lock*"}})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.deleted)
+		assert.Equal(t, 0, actual.failed)
+		assert.Equal(t, 2, actual.skipped)
+		assertFileExists(t, filepath.Join(leaveDir1, "empty"))
+		assertFileExists(t, leaveFile1)
+		assertFileExists(t, leaveFile2)
+		assertFileNotExists(t, deleteFile1)
+	})
+	t.Run("should only delete included files and directories", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+
+		deleteDir1 := filepath.Join(startDir, "upload", "chunks")
+		_ = os.MkdirAll(deleteDir1, 0755)
+		leaveDir1 := filepath.Join(startDir, "other")
+		_ = os.MkdirAll(leaveDir1, 0755)
+		deleteFile1 := createFileWithTime(t, startDir, "a-del-file.tmp", oldTime)
+		leaveFile1 := createFileWithTime(t, startDir, "b-stay-file", oldTime)
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, Include: []string{"upload", "**/*.tmp*"}})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 3, actual.deleted)
+		assert.Equal(t, 0, actual.failed)
+		assert.Equal(t, 2, actual.skipped)
+		assertFileNotExists(t, deleteDir1)
+		assertFileNotExists(t, deleteFile1)
+		// the included directory itself is deleted as soon as its contents are gone
+		assertFileNotExists(t, filepath.Join(startDir, "upload"))
+		assertFileExists(t, leaveDir1)
+		assertFileExists(t, leaveFile1)
+	})
+	t.Run("should delete old files before enforcing the size budget", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		now := nowClock.Now()
+		deleteFile1 := createFileWithSizeAndTime(t, startDir, "a-del-by-age", 4096, now.Add(-20*time.Hour))
+		deleteFile2 := createFileWithSizeAndTime(t, startDir, "b-del-by-quota", 4096, now.Add(-3*time.Hour))
+		leaveFile1 := createFileWithSizeAndTime(t, startDir, "c-stay", 4096, now.Add(-2*time.Hour))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MaxSizeInBytes: 5000})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 2, actual.deleted)
+		assert.Equal(t, int64(8), actual.deletedSizeKB)
+		assert.Equal(t, 1, actual.deletedByQuota)
+		assert.Equal(t, int64(4), actual.deletedByQuotaSizeKB)
+		assert.Equal(t, int64(4096), actual.remainingBytes)
+		assertFileNotExists(t, deleteFile1)
+		assertFileNotExists(t, deleteFile2)
+		assertFileExists(t, leaveFile1)
+	})
+	t.Run("should sum up the sizes of new and unselected files", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		now := nowClock.Now()
+		deleteFile1 := createFileWithSizeAndTime(t, startDir, "a-del", 4096, now.Add(-20*time.Hour))
+		leaveFile1 := createFileWithSizeAndTime(t, startDir, "b-stay", 1000, now.Add(-2*time.Hour))
+		leaveFile2 := createFileWithSizeAndTime(t, startDir, "c-stay.lock", 24, now.Add(-20*time.Hour))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, Exclude: []string{"*.lock*"}})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, int64(4096), actual.deletedBytes)
+		assert.Equal(t, int64(1024), actual.remainingBytes)
+		assertFileNotExists(t, deleteFile1)
+		assertFileExists(t, leaveFile1)
+		assertFileExists(t, leaveFile2)
+	})
+	t.Run("should keep recently accessed files when using atime", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		newTime := nowClock.Now().Add(-2 * time.Hour)
+		deleteFile1 := createFileWithTime(t, startDir, "a-del-file", oldTime)
+		leaveFile1 := createFileWithTime(t, startDir, "b-stay-file", oldTime)
+		require.NoError(t, os.Chtimes(leaveFile1, newTime, oldTime))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, TimeSource: TimeSourceAccess})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.deleted)
+		assert.Equal(t, 1, actual.skipped)
+		assertFileNotExists(t, deleteFile1)
+		assertFileExists(t, leaveFile1)
+	})
 }
 
 func assertFileExists(t *testing.T, path string) {
@@ -316,6 +821,29 @@ func createFileWithTime(t *testing.T, directory string, filenamePrefix string, f
 	return filePath
 }
 
+// failingFileRemover fails to remove the given paths and removes all other paths.
+type failingFileRemover struct {
+	failingPaths map[string]bool
+}
+
+func (f *failingFileRemover) Remove(path string) error {
+	if f.failingPaths[path] {
+		return os.ErrPermission
+	}
+
+	return os.Remove(path)
+}
+
+// cancellingFileRemover removes the given path and cancels the deletion run afterwards.
+type cancellingFileRemover struct {
+	cancel context.CancelFunc
+}
+
+func (c *cancellingFileRemover) Remove(path string) error {
+	c.cancel()
+	return os.Remove(path)
+}
+
 type mockFileRemover struct {
 	mock.Mock
 }
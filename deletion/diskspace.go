@@ -0,0 +1,83 @@
+package deletion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// freeDiskSpace reads the capacity of the filesystem that contains the given path. It can be replaced during tests.
+var freeDiskSpace = statDiskSpace
+
+// DiskSpace describes the capacity of the filesystem that contains a directory.
+type DiskSpace struct {
+	// FreeBytes contains the number of bytes that are available to unprivileged users.
+	FreeBytes uint64
+	// TotalBytes contains the size of the filesystem in bytes.
+	TotalBytes uint64
+}
+
+// FreePercent returns the available space in percent of the filesystem size.
+func (s DiskSpace) FreePercent() float64 {
+	if s.TotalBytes == 0 {
+		return 0
+	}
+
+	return float64(s.FreeBytes) / float64(s.TotalBytes) * 100
+}
+
+// FreeDiskSpace returns the capacity of the filesystem that contains the given path.
+func FreeDiskSpace(path string) (DiskSpace, error) {
+	return freeDiskSpace(path)
+}
+
+// Watermark defines how much free disk space must at least be left. A watermark is undercut if the free space drops
+// below its absolute or its relative threshold.
+type Watermark struct {
+	// MinFreeBytes sets the absolute threshold in bytes. Zero disables the absolute threshold.
+	MinFreeBytes uint64
+	// MinFreePercent sets the relative threshold in percent of the filesystem size. Zero disables the relative
+	// threshold.
+	MinFreePercent float64
+}
+
+// ParseWatermark parses a watermark given either in percent like "10%" or as size like "5GiB".
+func ParseWatermark(watermark string) (Watermark, error) {
+	trimmed := strings.TrimSpace(watermark)
+	if strings.HasSuffix(trimmed, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(trimmed, "%")), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return Watermark{}, fmt.Errorf("invalid watermark %q: expected a percentage between 0%% and 100%%", watermark)
+		}
+		return Watermark{MinFreePercent: percent}, nil
+	}
+
+	size, err := ParseSize(trimmed)
+	if err != nil {
+		return Watermark{}, fmt.Errorf("invalid watermark %q: expected a percentage like 10%% or a size like 5GiB", watermark)
+	}
+
+	return Watermark{MinFreeBytes: uint64(size)}, nil
+}
+
+// Enabled returns true if at least one threshold is set.
+func (w Watermark) Enabled() bool {
+	return w.MinFreeBytes > 0 || w.MinFreePercent > 0
+}
+
+// UndercutBy returns true if the free space of the given disk space drops below one of the thresholds.
+func (w Watermark) UndercutBy(space DiskSpace) bool {
+	if w.MinFreeBytes > 0 && space.FreeBytes < w.MinFreeBytes {
+		return true
+	}
+
+	return w.MinFreePercent > 0 && space.FreePercent() < w.MinFreePercent
+}
+
+func (w Watermark) String() string {
+	if w.MinFreePercent > 0 {
+		return fmt.Sprintf("%g%%", w.MinFreePercent)
+	}
+
+	return fmt.Sprintf("%d MB", w.MinFreeBytes/1024/1024)
+}
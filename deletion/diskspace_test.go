@@ -0,0 +1,65 @@
+package deletion
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestFreeDiskSpace(t *testing.T) {
+	t.Run("should read disk space of the temp directory", func(t *testing.T) {
+		actual, err := FreeDiskSpace(os.TempDir())
+
+		require.NoError(t, err)
+		assert.NotZero(t, actual.TotalBytes)
+		assert.LessOrEqual(t, actual.FreeBytes, actual.TotalBytes)
+	})
+	t.Run("should fail on missing directory", func(t *testing.T) {
+		_, err := FreeDiskSpace("/this/directory/does/not/exist")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not read filesystem statistics")
+	})
+}
+
+func TestDiskSpace_FreePercent(t *testing.T) {
+	assert.Equal(t, 25.0, DiskSpace{FreeBytes: 1, TotalBytes: 4}.FreePercent())
+	assert.Equal(t, 0.0, DiskSpace{}.FreePercent())
+}
+
+func TestParseWatermark(t *testing.T) {
+	t.Run("should parse percentage", func(t *testing.T) {
+		actual, err := ParseWatermark("12.5%")
+
+		require.NoError(t, err)
+		assert.Equal(t, Watermark{MinFreePercent: 12.5}, actual)
+		assert.Equal(t, "12.5%", actual.String())
+	})
+	t.Run("should parse size", func(t *testing.T) {
+		actual, err := ParseWatermark("5GiB")
+
+		require.NoError(t, err)
+		assert.Equal(t, Watermark{MinFreeBytes: 5 << 30}, actual)
+		assert.Equal(t, "5120 MB", actual.String())
+	})
+	for _, invalid := range []string{"101%", "-1%", "a%", "5XB"} {
+		t.Run("should fail on "+invalid, func(t *testing.T) {
+			_, err := ParseWatermark(invalid)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid watermark")
+		})
+	}
+}
+
+func TestWatermark_UndercutBy(t *testing.T) {
+	space := DiskSpace{FreeBytes: 100, TotalBytes: 1000}
+
+	assert.False(t, Watermark{}.UndercutBy(space))
+	assert.False(t, Watermark{}.Enabled())
+	assert.True(t, Watermark{MinFreeBytes: 101}.UndercutBy(space))
+	assert.False(t, Watermark{MinFreeBytes: 100}.UndercutBy(space))
+	assert.True(t, Watermark{MinFreePercent: 10.1}.UndercutBy(space))
+	assert.False(t, Watermark{MinFreePercent: 10}.UndercutBy(space))
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package deletion
+
+import (
+	errors2 "github.com/pkg/errors"
+	"syscall"
+)
+
+func statDiskSpace(path string) (DiskSpace, error) {
+	stat := syscall.Statfs_t{}
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return DiskSpace{}, errors2.Wrapf(err, "could not read filesystem statistics of %q", path)
+	}
+
+	blockSize := uint64(stat.Bsize)
+	return DiskSpace{
+		FreeBytes:  uint64(stat.Bavail) * blockSize,
+		TotalBytes: uint64(stat.Blocks) * blockSize,
+	}, nil
+}
@@ -0,0 +1,7 @@
+package deletion
+
+import "errors"
+
+func statDiskSpace(string) (DiskSpace, error) {
+	return DiskSpace{}, errors.New("reading the free disk space is not supported on windows")
+}
@@ -0,0 +1,273 @@
+package deletion
+
+import (
+	"compress/gzip"
+	"fmt"
+	errors2 "github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// StageActionCompress compresses a file with gzip in place. Files that are already compressed are kept.
+	StageActionCompress StageAction = "compress"
+	// StageActionDelete deletes a file.
+	StageActionDelete StageAction = "delete"
+
+	// compressedFileSuffix marks files that were compressed by a stage or that were already compressed before.
+	compressedFileSuffix = ".gz"
+	// compressedTimeFormat adds the modification time to the name of a compressed file if the plain name is taken.
+	compressedTimeFormat = "20060102T150405Z"
+)
+
+// StageAction names what happens to a file once it reached the age of a lifecycle stage.
+type StageAction string
+
+// Stage applies an action to all files that are older than its age. Stages are ordered by ascending age so that
+// every file is handled by the last stage whose age it exceeds, f. e. compressing files older than 2h and deleting
+// files older than 7d.
+type Stage struct {
+	Action StageAction `yaml:"action"`
+	Age    Duration    `yaml:"age"`
+}
+
+// ParseStage parses a stage like "compress:2h" or "delete:7d". The age accepts the same formats as ParseDuration,
+// plain integers are counted in hours.
+func ParseStage(stage string) (Stage, error) {
+	action, age, found := strings.Cut(strings.TrimSpace(stage), ":")
+	if !found {
+		return Stage{}, fmt.Errorf("invalid stage %q: expected action:age like compress:2h or delete:7d", stage)
+	}
+
+	parsedAction, err := parseStageAction(action)
+	if err != nil {
+		return Stage{}, err
+	}
+	parsedAge, err := ParseDuration(age, time.Hour)
+	if err != nil {
+		return Stage{}, errors2.Wrapf(err, "invalid stage %q", stage)
+	}
+
+	return Stage{Action: parsedAction, Age: Duration(parsedAge)}, nil
+}
+
+func parseStageAction(action string) (StageAction, error) {
+	switch StageAction(strings.ToLower(strings.TrimSpace(action))) {
+	case StageActionCompress:
+		return StageActionCompress, nil
+	case StageActionDelete:
+		return StageActionDelete, nil
+	default:
+		return "", fmt.Errorf("invalid stage action %q: expected %s or %s", action, StageActionCompress,
+			StageActionDelete)
+	}
+}
+
+// validateStages checks that the given stages have known actions and strictly ascending ages. A stage after a delete
+// stage would never apply.
+func validateStages(stages []Stage) ([]Stage, error) {
+	validated := make([]Stage, 0, len(stages))
+	for i, stage := range stages {
+		action, err := parseStageAction(string(stage.Action))
+		if err != nil {
+			return nil, err
+		}
+		if stage.Age < 0 {
+			return nil, fmt.Errorf("invalid stage %s: age must be zero or positive", action)
+		}
+		if i > 0 && stage.Age <= stages[i-1].Age {
+			return nil, fmt.Errorf("invalid stage %s: stages must be ordered by strictly ascending age", action)
+		}
+		if i > 0 && validated[i-1].Action == StageActionDelete {
+			return nil, fmt.Errorf("invalid stage %s: no stage can follow a delete stage", action)
+		}
+
+		validated = append(validated, Stage{Action: action, Age: stage.Age})
+	}
+
+	return validated, nil
+}
+
+// actionFor returns the action of the last stage whose age the given file time exceeds. Without stages, files older
+// than the max. age are deleted. An empty action keeps the file.
+func (r *compiledRule) actionFor(fileTime time.Time) StageAction {
+	if len(r.stages) == 0 {
+		if fileOlderThan(r.maxAge, fileTime) {
+			return StageActionDelete
+		}
+		return ""
+	}
+
+	var action StageAction
+	for _, stage := range r.stages {
+		if fileOlderThan(time.Duration(stage.Age), fileTime) {
+			action = stage.Action
+		}
+	}
+
+	return action
+}
+
+// hasCompressStage returns true if any of the given rules compresses files.
+func hasCompressStage(rules []*compiledRule) bool {
+	for _, rule := range rules {
+		for _, stage := range rule.stages {
+			if stage.Action == StageActionCompress {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// compressible returns true if the given file can be compressed by a stage. Compressed files and anything but
+// regular files are kept.
+func compressible(path string, info os.FileInfo) bool {
+	return info.Mode().IsRegular() && !strings.HasSuffix(path, compressedFileSuffix)
+}
+
+// compressFile replaces the given file with a gzip-compressed copy that keeps its modification time. The file is
+// kept if it is in use or changed during compression. The returned error is only set if the error budget is
+// exceeded.
+func (d *deleter) compressFile(path string, info os.FileInfo) error {
+	if d.SkipOpenFiles && d.isOpen(path) {
+		d.Results.skipInUse(path)
+		d.Results.remain(info.Size())
+		return nil
+	}
+
+	if d.DryRun {
+		d.Results.compress(path, info, info.Size())
+		d.Results.remain(info.Size())
+		d.addCompressedQuotaCandidate(path, info)
+		return nil
+	}
+
+	target, compressedSize, changed, err := gzipInPlace(path, info)
+	if err != nil {
+		d.Results.remain(info.Size())
+		return d.failPath(path, errors2.Wrapf(err, "could not compress path %q", path))
+	}
+	if changed {
+		d.Results.skipChanged(path)
+		d.Results.remain(info.Size())
+		return nil
+	}
+
+	d.Results.compress(path, info, compressedSize)
+	d.Results.remain(compressedSize)
+	// the size budget only sees the compressed file
+	compressedInfo, err := os.Lstat(target)
+	if err == nil {
+		d.addCompressedQuotaCandidate(target, compressedInfo)
+	}
+	return nil
+}
+
+// gzipInPlace writes the content of the given file into a new file with the suffix .gz next to it and deletes the
+// original file afterwards. The compressed file is written under a temporary name first so that an interrupted
+// compression never leaves a truncated .gz file behind. It returns the compressed file and true if the original file
+// changed in the meantime, in which case it is kept and no compressed file is created.
+func gzipInPlace(path string, info os.FileInfo) (string, int64, bool, error) {
+	target, err := compressedFileName(path, info)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	partFile := target + ".part"
+	compressedSize, err := writeGzipFile(path, partFile, info)
+	if err != nil {
+		_ = os.Remove(partFile)
+		return "", 0, false, err
+	}
+
+	changed, err := fileChanged(path, info)
+	if err == nil && changed {
+		_ = os.Remove(partFile)
+		return "", 0, true, nil
+	}
+	if err == nil {
+		err = os.Chtimes(partFile, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(partFile, target)
+	}
+	if err != nil {
+		_ = os.Remove(partFile)
+		return "", 0, false, err
+	}
+
+	err = remover.Remove(path)
+	if err != nil {
+		// keep the original file as the only copy so that nothing exists twice
+		_ = os.Remove(target)
+		return "", 0, false, err
+	}
+
+	return target, compressedSize, false, nil
+}
+
+// compressedFileName returns a free name for the compressed copy of the given file. Numbered log rotations re-use
+// their names, f. e. app.log.1, while the compressed copy of the previous app.log.1 still exists. In this case, the
+// modification time of the file is added to the name, f. e. app.log.1.20240131T235959Z.gz.
+func compressedFileName(path string, info os.FileInfo) (string, error) {
+	candidates := []string{
+		path + compressedFileSuffix,
+		path + "." + info.ModTime().UTC().Format(compressedTimeFormat) + compressedFileSuffix,
+	}
+	for _, candidate := range candidates {
+		_, err := os.Lstat(candidate)
+		if os.IsNotExist(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("compressed file %q already exists", candidates[len(candidates)-1])
+}
+
+// writeGzipFile compresses the given file into a new file with the same permissions and returns its size.
+func writeGzipFile(path, target string, info os.FileInfo) (int64, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = source.Close() }()
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+
+	writer := gzip.NewWriter(file)
+	writer.Name = filepath.Base(path)
+	writer.ModTime = info.ModTime()
+
+	_, err = io.Copy(writer, source)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	compressed, err := os.Stat(target)
+	if err != nil {
+		return 0, err
+	}
+
+	return compressed.Size(), nil
+}
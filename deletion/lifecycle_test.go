@@ -0,0 +1,249 @@
+package deletion
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseStage(t *testing.T) {
+	t.Run("should parse stages", func(t *testing.T) {
+		actual, err := ParseStage("compress:2h")
+		require.NoError(t, err)
+		assert.Equal(t, Stage{Action: StageActionCompress, Age: Duration(2 * time.Hour)}, actual)
+
+		actual, err = ParseStage(" Delete:7d ")
+		require.NoError(t, err)
+		assert.Equal(t, Stage{Action: StageActionDelete, Age: Duration(7 * day)}, actual)
+
+		actual, err = ParseStage("delete:24")
+		require.NoError(t, err)
+		assert.Equal(t, Stage{Action: StageActionDelete, Age: Duration(24 * time.Hour)}, actual)
+	})
+	for _, invalid := range []string{"", "compress", "move:2h", "delete:soon"} {
+		t.Run("should fail on "+invalid, func(t *testing.T) {
+			_, err := ParseStage(invalid)
+
+			require.Error(t, err)
+		})
+	}
+}
+
+func Test_validateStages(t *testing.T) {
+	compress := func(age time.Duration) Stage { return Stage{Action: StageActionCompress, Age: Duration(age)} }
+	deleteStage := func(age time.Duration) Stage { return Stage{Action: StageActionDelete, Age: Duration(age)} }
+
+	t.Run("should accept ascending stages", func(t *testing.T) {
+		actual, err := validateStages([]Stage{compress(2 * time.Hour), deleteStage(week)})
+
+		require.NoError(t, err)
+		assert.Equal(t, []Stage{compress(2 * time.Hour), deleteStage(week)}, actual)
+	})
+	t.Run("should fail on unordered stages", func(t *testing.T) {
+		_, err := validateStages([]Stage{compress(week), deleteStage(week)})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "stages must be ordered by strictly ascending age")
+	})
+	t.Run("should fail on stages after delete", func(t *testing.T) {
+		_, err := validateStages([]Stage{deleteStage(time.Hour), compress(week)})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no stage can follow a delete stage")
+	})
+	t.Run("should fail on unknown actions", func(t *testing.T) {
+		_, err := validateStages([]Stage{{Action: "move", Age: Duration(time.Hour)}})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid stage action "move"`)
+	})
+}
+
+func Test_deleter_Execute_withStages(t *testing.T) {
+	stages := []Stage{
+		{Action: StageActionCompress, Age: Duration(2 * time.Hour)},
+		{Action: StageActionDelete, Age: Duration(week)},
+	}
+
+	t.Run("should compress and delete files by their age", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		now := nowClock.Now()
+		compressTime := now.Add(-3 * time.Hour)
+		compressFile := createFileWithSizeAndTime(t, startDir, "a-compress", 4096, compressTime)
+		alreadyCompressedFile := createFileWithTime(t, startDir, "b-keep", compressTime)
+		require.NoError(t, os.Rename(alreadyCompressedFile, alreadyCompressedFile+".gz"))
+		deleteFile := createFileWithSizeAndTime(t, startDir, "c-delete", 42, now.Add(-8*day))
+		leaveFile := createFileWithTime(t, startDir, "d-new", now)
+
+		sut, err := New(Args{Directory: startDir, MaxAge: time.Hour, Stages: stages})
+		require.NoError(t, err)
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.deleted)
+		assert.Equal(t, 1, actual.compressed)
+		assert.Equal(t, int64(4096), actual.compressedBytes)
+		assert.True(t, actual.compressionSavedBytes > 0)
+		assertFileNotExists(t, compressFile)
+		assertFileNotExists(t, deleteFile)
+		assertFileExists(t, alreadyCompressedFile+".gz")
+		assertFileExists(t, leaveFile)
+
+		compressed, err := os.Stat(compressFile + ".gz")
+		require.NoError(t, err)
+		assert.True(t, compressed.ModTime().Equal(compressTime))
+		assert.Equal(t, make([]byte, 4096), readGzipFile(t, compressFile+".gz"))
+	})
+	t.Run("should only report compressions in dry-run mode", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		compressFile := createFileWithSizeAndTime(t, startDir, "a-compress", 4096, nowClock.Now().Add(-3*time.Hour))
+
+		sut, err := New(Args{Directory: startDir, Stages: stages, DryRun: true})
+		require.NoError(t, err)
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.compressed)
+		assert.Equal(t, int64(0), actual.compressionSavedBytes)
+		assertFileExists(t, compressFile)
+		assertFileNotExists(t, compressFile+".gz")
+	})
+	t.Run("should count compressed files for the size budget", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		now := nowClock.Now()
+		var files []string
+		for i, name := range []string{"a-oldest", "b-older", "c-newest"} {
+			file := createFileWithTime(t, startDir, name, now)
+			// random content hardly shrinks by compression
+			content := make([]byte, 3000)
+			_, _ = rand.New(rand.NewSource(int64(i))).Read(content)
+			require.NoError(t, ioutil.WriteFile(file, content, 0644))
+			fileTime := now.Add(-time.Duration(5-i) * time.Hour)
+			require.NoError(t, os.Chtimes(file, fileTime, fileTime))
+			files = append(files, file)
+		}
+
+		sut, err := New(Args{Directory: startDir, Stages: stages, MaxSizeInBytes: 5000})
+		require.NoError(t, err)
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 3, actual.compressed)
+		assert.Equal(t, 2, actual.deletedByQuota)
+		assert.Equal(t, 0, actual.skipped)
+		assert.True(t, actual.remainingBytes <= 5000)
+		assertFileNotExists(t, files[0]+".gz")
+		assertFileNotExists(t, files[1]+".gz")
+		assertFileExists(t, files[2]+".gz")
+	})
+	t.Run("should add the modification time if the compressed file already exists", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		compressTime := nowClock.Now().Add(-3 * time.Hour)
+		compressFile := createFileWithTime(t, startDir, "a-compress", compressTime)
+		require.NoError(t, ioutil.WriteFile(compressFile+".gz", []byte("other"), 0644))
+		require.NoError(t, os.Chtimes(compressFile+".gz", nowClock.Now(), nowClock.Now()))
+
+		sut, err := New(Args{Directory: startDir, Stages: stages})
+		require.NoError(t, err)
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.compressed)
+		assertFileNotExists(t, compressFile)
+		assertFileExists(t, compressFile+"."+compressTime.UTC().Format(compressedTimeFormat)+".gz")
+		content, _ := ioutil.ReadFile(compressFile + ".gz")
+		assert.Equal(t, "other", string(content))
+	})
+	t.Run("should keep the file if all compressed file names are taken", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		compressTime := nowClock.Now().Add(-3 * time.Hour)
+		compressFile := createFileWithTime(t, startDir, "a-compress", compressTime)
+		for _, taken := range []string{".gz", "." + compressTime.UTC().Format(compressedTimeFormat) + ".gz"} {
+			require.NoError(t, ioutil.WriteFile(compressFile+taken, []byte("other"), 0644))
+			require.NoError(t, os.Chtimes(compressFile+taken, nowClock.Now(), nowClock.Now()))
+		}
+
+		sut, err := New(Args{Directory: startDir, Stages: stages, MaxErrors: -1})
+		require.NoError(t, err)
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 0, actual.compressed)
+		assert.Equal(t, 1, actual.failed)
+		assertFileExists(t, compressFile)
+		content, _ := ioutil.ReadFile(compressFile + ".gz")
+		assert.Equal(t, "other", string(content))
+	})
+	t.Run("should apply the stages of a policy rule", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		logDir := filepath.Join(startDir, "logs")
+		require.NoError(t, os.MkdirAll(logDir, 0755))
+		oldTime := nowClock.Now().Add(-3 * time.Hour)
+		compressFile := createFileWithTime(t, logDir, "a-compress", oldTime)
+		deleteFile := createFileWithTime(t, startDir, "b-delete", oldTime)
+
+		policy := &Policy{Rules: []Rule{{Path: "logs", Stages: stages}}}
+		sut, err := New(Args{Directory: startDir, MaxAge: time.Hour, Policy: policy})
+		require.NoError(t, err)
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.deleted)
+		assert.Equal(t, 1, actual.compressed)
+		assertFileNotExists(t, compressFile)
+		assertFileExists(t, compressFile+".gz")
+		assertFileNotExists(t, deleteFile)
+		assertFileExists(t, logDir)
+	})
+}
+
+func readGzipFile(t *testing.T, file string) []byte {
+	t.Helper()
+
+	compressed, err := os.Open(file)
+	require.NoError(t, err)
+	defer func() { _ = compressed.Close() }()
+	reader, err := gzip.NewReader(compressed)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+
+	return content
+}
@@ -0,0 +1,86 @@
+package deletion
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// procDirectory contains the process information of the kernel. It can be replaced during tests.
+var procDirectory = "/proc"
+
+// openFileIndex contains the paths of all files that were held open by any process when the index was built.
+type openFileIndex map[string]bool
+
+// newOpenFileIndex scans the file descriptors of all processes in the given proc directory. Processes whose file
+// descriptors cannot be read, f. e. because of missing permissions or because they just ended, are left out.
+func newOpenFileIndex(procDir string) openFileIndex {
+	index := openFileIndex{}
+
+	processes, err := os.ReadDir(procDir)
+	if err != nil {
+		log.Warningf("could not read process information from %s: %v", procDir, err)
+		return index
+	}
+
+	unreadable := 0
+	for _, process := range processes {
+		if !process.IsDir() || !isNumeric(process.Name()) {
+			continue
+		}
+
+		fdDir := filepath.Join(procDir, process.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			unreadable++
+			continue
+		}
+
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			// sockets, pipes and the like do not point to absolute paths
+			if err != nil || !filepath.IsAbs(target) {
+				continue
+			}
+			index[strings.TrimSuffix(target, " (deleted)")] = true
+		}
+	}
+
+	if unreadable > 0 {
+		log.Debugf("could not read open files of %d processes", unreadable)
+	}
+	log.Debugf("found %d open files", len(index))
+
+	return index
+}
+
+// isOpen returns true if the given absolute path is held open by any process.
+func (i openFileIndex) isOpen(path string) bool {
+	return i[path]
+}
+
+func isNumeric(name string) bool {
+	for _, r := range name {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// realPath resolves the start directory like the kernel does for open files, so that the relative path of a file can
+// be looked up in the open file index.
+func realPath(directory string) string {
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return directory
+	}
+
+	resolved, err := filepath.EvalSymlinks(absDirectory)
+	if err != nil {
+		return absDirectory
+	}
+
+	return resolved
+}
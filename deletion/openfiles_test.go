@@ -0,0 +1,91 @@
+package deletion
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_newOpenFileIndex(t *testing.T) {
+	t.Run("should index absolute targets of file descriptors", func(t *testing.T) {
+		procDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-proc-")
+		defer func() { _ = os.RemoveAll(procDir) }()
+		createFakeFileDescriptor(t, procDir, "1", "3", "/opt/confluence/temp/export.zip")
+		createFakeFileDescriptor(t, procDir, "1", "4", "socket:[12345]")
+		createFakeFileDescriptor(t, procDir, "42", "3", "/opt/confluence/temp/removed (deleted)")
+		createFakeFileDescriptor(t, procDir, "self", "3", "/opt/confluence/temp/self")
+		_ = os.MkdirAll(filepath.Join(procDir, "43"), 0755)
+
+		// when
+		actual := newOpenFileIndex(procDir)
+
+		// then
+		assert.Equal(t, openFileIndex{
+			"/opt/confluence/temp/export.zip": true,
+			"/opt/confluence/temp/removed":    true,
+		}, actual)
+	})
+	t.Run("should return empty index for missing proc directory", func(t *testing.T) {
+		actual := newOpenFileIndex("/this/directory/does/not/exist")
+
+		assert.Empty(t, actual)
+	})
+	t.Run("should find files opened by this process", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("requires the proc filesystem")
+		}
+		file, err := ioutil.TempFile(os.TempDir(), "tempdel-")
+		require.NoError(t, err)
+		defer func() { _ = os.Remove(file.Name()) }()
+		defer func() { _ = file.Close() }()
+
+		// when
+		actual := newOpenFileIndex("/proc")
+
+		// then
+		assert.True(t, actual.isOpen(realPath(file.Name())))
+	})
+}
+
+func Test_deleter_Execute_skipOpenFiles(t *testing.T) {
+	t.Run("should not delete open files", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		openFile := createFileWithTime(t, startDir, "a-open-file", oldTime)
+		deleteFile := createFileWithTime(t, startDir, "b-del-file", oldTime)
+
+		procDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-proc-")
+		defer func() { _ = os.RemoveAll(procDir) }()
+		createFakeFileDescriptor(t, procDir, "1", "3", realPath(openFile))
+		procDirectory = procDir
+		defer func() { procDirectory = "/proc" }()
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, SkipOpenFiles: true})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual.deleted)
+		assert.Equal(t, 1, actual.inUse)
+		assertFileExists(t, openFile)
+		assertFileNotExists(t, deleteFile)
+	})
+}
+
+func createFakeFileDescriptor(t *testing.T, procDir, pid, fd, target string) {
+	t.Helper()
+
+	fdDir := filepath.Join(procDir, pid, "fd")
+	require.NoError(t, os.MkdirAll(fdDir, 0755))
+	require.NoError(t, os.Symlink(target, filepath.Join(fdDir, fd)))
+}
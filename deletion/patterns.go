@@ -0,0 +1,80 @@
+package deletion
+
+import (
+	errors2 "github.com/pkg/errors"
+	"path"
+	"strings"
+)
+
+const anySegmentsWildcard = "**"
+
+// patterns matches slash-separated paths relative to the start directory against glob patterns. Next to the syntax of
+// path.Match the segment "**" matches zero or more path segments, f. e. "**/lucene" matches "lucene" as well as
+// "plugins/cache/lucene".
+type patterns []string
+
+func newPatterns(globs []string) (patterns, error) {
+	result := patterns{}
+	for _, glob := range globs {
+		glob = strings.Trim(path.Clean(strings.TrimSpace(glob)), "/")
+		if glob == "" || glob == "." {
+			continue
+		}
+
+		for _, segment := range strings.Split(glob, "/") {
+			// path.Match checks the pattern syntax only when the name does not match early
+			if _, err := path.Match(segment, "\x00"); err != nil {
+				return nil, errors2.Wrapf(err, "invalid glob pattern %q", glob)
+			}
+		}
+		result = append(result, glob)
+	}
+
+	return result, nil
+}
+
+// matches returns true if the given relative path matches at least one pattern.
+func (p patterns) matches(relPath string) bool {
+	nameSegments := strings.Split(relPath, "/")
+	for _, glob := range p {
+		if matchSegments(strings.Split(glob, "/"), nameSegments) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesPathOrParent returns true if the given relative path or one of its parent directories matches at least one
+// pattern. This way a pattern naming a directory selects everything inside that directory.
+func (p patterns) matchesPathOrParent(relPath string) bool {
+	for current := relPath; current != "." && current != "/" && current != ""; current = path.Dir(current) {
+		if p.matches(current) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchSegments(globSegments, nameSegments []string) bool {
+	if len(globSegments) == 0 {
+		return len(nameSegments) == 0
+	}
+
+	if globSegments[0] == anySegmentsWildcard {
+		for skip := 0; skip <= len(nameSegments); skip++ {
+			if matchSegments(globSegments[1:], nameSegments[skip:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(nameSegments) == 0 {
+		return false
+	}
+
+	matched, _ := path.Match(globSegments[0], nameSegments[0])
+	return matched && matchSegments(globSegments[1:], nameSegments[1:])
+}
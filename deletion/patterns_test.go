@@ -0,0 +1,60 @@
+package deletion
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_newPatterns(t *testing.T) {
+	t.Run("should normalize patterns and ignore empty ones", func(t *testing.T) {
+		actual, err := newPatterns([]string{"/lucene/", " ", "plugins//cache", "."})
+
+		require.NoError(t, err)
+		assert.Equal(t, patterns{"lucene", "plugins/cache"}, actual)
+	})
+	t.Run("should fail on invalid pattern", func(t *testing.T) {
+		_, err := newPatterns([]string{"valid", "**/[invalid"})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid glob pattern "**/[invalid"`)
+	})
+}
+
+func Test_patterns_matches(t *testing.T) {
+	tests := []struct {
+		name    string
+		glob    string
+		relPath string
+		want    bool
+	}{
+		{"should match exact path", "lucene", "lucene", true},
+		{"should not match sub path", "lucene", "lucene/index", false},
+		{"should match wildcard in segment", "*.tmp", "upload.tmp", true},
+		{"should not match wildcard across segments", "*.tmp", "a/upload.tmp", false},
+		{"should match double star on top level", "**/lucene", "lucene", true},
+		{"should match double star on nested level", "**/lucene", "plugins/cache/lucene", true},
+		{"should match double star in the middle", "plugins/**/*.jar", "plugins/a/b/c.jar", true},
+		{"should match double star in the middle without directories", "plugins/**/*.jar", "plugins/c.jar", true},
+		{"should match trailing double star", "plugins/**", "plugins/a/b", true},
+		{"should not match other directory", "plugins/**/*.jar", "export/c.jar", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut, err := newPatterns([]string{tt.glob})
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, sut.matches(tt.relPath))
+		})
+	}
+}
+
+func Test_patterns_matchesPathOrParent(t *testing.T) {
+	sut, _ := newPatterns([]string{"export", "**/*.zip"})
+
+	assert.True(t, sut.matchesPathOrParent("export"))
+	assert.True(t, sut.matchesPathOrParent("export/a/b.txt"))
+	assert.True(t, sut.matchesPathOrParent("upload/b.zip"))
+	assert.False(t, sut.matchesPathOrParent("upload/b.txt"))
+	assert.False(t, sut.matchesPathOrParent("."))
+}
@@ -0,0 +1,192 @@
+package deletion
+
+import (
+	"bytes"
+	"fmt"
+	errors2 "github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Policy assigns their own deletion settings to subdirectories of the start directory.
+type Policy struct {
+	// Rules contains the settings per subdirectory. If several rules apply to a path, the rule with the most specific
+	// path wins.
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule configures the deletion of all files and directories below a subdirectory of the start directory. Settings
+// which are not set inherit the values of Args.
+type Rule struct {
+	// Path names the subdirectory relative to the start directory.
+	Path string `yaml:"path"`
+	// MaxAge overrides Args.MaxAge for files below Path. Files below Path are deleted once they are older, even if
+	// Args.Stages are set.
+	MaxAge *Duration `yaml:"maxAge,omitempty"`
+	// Stages overrides Args.Stages for files below Path. It cannot be combined with MaxAge.
+	Stages []Stage `yaml:"stages,omitempty"`
+	// Include restricts the deletion to paths below Path that match at least one of these glob patterns. The
+	// patterns are evaluated relative to Path and apply in addition to Args.Include.
+	Include []string `yaml:"include,omitempty"`
+	// Exclude protects paths below Path that match at least one of these glob patterns. The patterns are evaluated
+	// relative to Path and apply in addition to Args.Exclude.
+	Exclude []string `yaml:"exclude,omitempty"`
+	// DeleteEmptyDirectories controls whether empty directories below Path will be deleted. Defaults to the opposite of
+	// Args.KeepEmptyDirectories.
+	DeleteEmptyDirectories *bool `yaml:"deleteEmptyDirectories,omitempty"`
+	// MinDirectoryAge overrides Args.MinDirectoryAge for directories below Path.
+	MinDirectoryAge *Duration `yaml:"minDirectoryAge,omitempty"`
+}
+
+// Duration is a time.Duration that can be read from policy files in the same formats as ParseDuration accepts.
+// Plain integers are counted in hours.
+type Duration time.Duration
+
+// UnmarshalYAML parses a duration like "90m", "2d" or "P1DT12H".
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := ParseDuration(value.Value, time.Hour)
+	if err != nil {
+		return errors2.Wrapf(err, "line %d", value.Line)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalYAML writes the duration in the Go duration format.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// LoadPolicy reads a policy from a YAML or JSON file.
+func LoadPolicy(file string) (*Policy, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors2.Wrapf(err, "could not read policy file %q", file)
+	}
+
+	policy := &Policy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	// a misspelled setting must not silently fall back to the global settings
+	decoder.KnownFields(true)
+	err = decoder.Decode(policy)
+	if err != nil && err != io.EOF {
+		return nil, errors2.Wrapf(err, "could not parse policy file %q", file)
+	}
+
+	return policy, nil
+}
+
+// compiledRule contains the effective settings of a rule, merged with the settings of Args.
+type compiledRule struct {
+	// path is the slash-separated path relative to the start directory. The start directory itself is ".".
+	path   string
+	maxAge time.Duration
+	// stages replaces maxAge if it is not empty.
+	stages                 []Stage
+	minDirectoryAge        time.Duration
+	include                patterns
+	exclude                patterns
+	deleteEmptyDirectories bool
+}
+
+// compileRules creates the effective rules of the given policy. The first rule always belongs to the start directory.
+func compileRules(args Args, policy *Policy) ([]*compiledRule, error) {
+	rootRule := &compiledRule{
+		path:                   ".",
+		maxAge:                 args.MaxAge,
+		minDirectoryAge:        args.MinDirectoryAge,
+		deleteEmptyDirectories: !args.KeepEmptyDirectories,
+	}
+	if len(args.Stages) > 0 {
+		// the stages of Args are validated by New
+		rootRule.stages = args.Stages
+	}
+	rules := []*compiledRule{rootRule}
+	if policy == nil {
+		return rules, nil
+	}
+
+	seenPaths := map[string]bool{}
+	for _, rule := range policy.Rules {
+		rulePath := path.Clean(strings.Trim(strings.TrimSpace(rule.Path), "/"))
+		if rulePath == "." || rulePath == ".." || strings.HasPrefix(rulePath, "../") {
+			return nil, fmt.Errorf("invalid rule path %q: must be a subdirectory of the start directory", rule.Path)
+		}
+		if seenPaths[rulePath] {
+			return nil, fmt.Errorf("invalid rule path %q: path is configured more than once", rule.Path)
+		}
+		seenPaths[rulePath] = true
+
+		compiled := &compiledRule{
+			path:                   rulePath,
+			maxAge:                 rootRule.maxAge,
+			stages:                 rootRule.stages,
+			minDirectoryAge:        rootRule.minDirectoryAge,
+			deleteEmptyDirectories: rootRule.deleteEmptyDirectories,
+		}
+		if rule.MaxAge != nil && len(rule.Stages) > 0 {
+			return nil, fmt.Errorf("invalid rule %q: maxAge and stages cannot be used together", rule.Path)
+		}
+		if rule.MaxAge != nil {
+			compiled.maxAge = time.Duration(*rule.MaxAge)
+			compiled.stages = nil
+		}
+		if len(rule.Stages) > 0 {
+			var err error
+			compiled.stages, err = validateStages(rule.Stages)
+			if err != nil {
+				return nil, errors2.Wrapf(err, "stages of rule %q are invalid", rule.Path)
+			}
+		}
+		if rule.MinDirectoryAge != nil {
+			compiled.minDirectoryAge = time.Duration(*rule.MinDirectoryAge)
+		}
+		if rule.DeleteEmptyDirectories != nil {
+			compiled.deleteEmptyDirectories = *rule.DeleteEmptyDirectories
+		}
+
+		var err error
+		compiled.include, err = newPatterns(rule.Include)
+		if err != nil {
+			return nil, errors2.Wrapf(err, "include patterns of rule %q are invalid", rule.Path)
+		}
+		compiled.exclude, err = newPatterns(rule.Exclude)
+		if err != nil {
+			return nil, errors2.Wrapf(err, "exclude patterns of rule %q are invalid", rule.Path)
+		}
+
+		rules = append(rules, compiled)
+	}
+
+	return rules, nil
+}
+
+// contains returns true if the given relative path lies below the rule's path.
+func (r *compiledRule) contains(relPath string) bool {
+	return r.path == "." || strings.HasPrefix(relPath, r.path+"/")
+}
+
+// relativePath returns the given path relative to the rule's path.
+func (r *compiledRule) relativePath(relPath string) string {
+	if r.path == "." {
+		return relPath
+	}
+
+	return strings.TrimPrefix(relPath, r.path+"/")
+}
+
+// excludes returns true if the rule's exclude patterns match the given path relative to the start directory.
+func (r *compiledRule) excludes(relPath string) bool {
+	return r.exclude.matches(r.relativePath(relPath))
+}
+
+// includes returns true if the rule has no include patterns or if they match the given path relative to the start
+// directory.
+func (r *compiledRule) includes(relPath string) bool {
+	return len(r.include) == 0 || r.include.matchesPathOrParent(r.relativePath(relPath))
+}
@@ -0,0 +1,249 @@
+package deletion
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPolicyYAML = `rules:
+  - path: upload
+    maxAge: 30m
+  - path: export/
+    maxAge: P2D
+    exclude: ["**/*.lock"]
+    deleteEmptyDirectories: false
+`
+
+const testPolicyJSON = `{"rules": [{"path": "upload", "maxAge": "30m"}, {"path": "export", "maxAge": 48, "include": ["*.zip"]}]}`
+
+func TestLoadPolicy(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	t.Run("should load YAML policy", func(t *testing.T) {
+		file := filepath.Join(dir, "policy.yaml")
+		_ = ioutil.WriteFile(file, []byte(testPolicyYAML), 0644)
+
+		// when
+		actual, err := LoadPolicy(file)
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, actual.Rules, 2)
+		assert.Equal(t, "upload", actual.Rules[0].Path)
+		assert.Equal(t, Duration(30*time.Minute), *actual.Rules[0].MaxAge)
+		assert.Nil(t, actual.Rules[0].DeleteEmptyDirectories)
+		assert.Equal(t, "export/", actual.Rules[1].Path)
+		assert.Equal(t, Duration(48*time.Hour), *actual.Rules[1].MaxAge)
+		assert.Equal(t, []string{"**/*.lock"}, actual.Rules[1].Exclude)
+		assert.False(t, *actual.Rules[1].DeleteEmptyDirectories)
+	})
+	t.Run("should load JSON policy", func(t *testing.T) {
+		file := filepath.Join(dir, "policy.json")
+		_ = ioutil.WriteFile(file, []byte(testPolicyJSON), 0644)
+
+		// when
+		actual, err := LoadPolicy(file)
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, actual.Rules, 2)
+		assert.Equal(t, Duration(48*time.Hour), *actual.Rules[1].MaxAge)
+		assert.Equal(t, []string{"*.zip"}, actual.Rules[1].Include)
+	})
+	t.Run("should load stages", func(t *testing.T) {
+		file := filepath.Join(dir, "stages.yaml")
+		_ = ioutil.WriteFile(file, []byte("rules:\n  - path: logs\n    stages:\n      - {action: compress, age: 2h}\n"+
+			"      - {action: delete, age: 7d}\n"), 0644)
+
+		// when
+		actual, err := LoadPolicy(file)
+
+		// then
+		require.NoError(t, err)
+		require.Len(t, actual.Rules, 1)
+		assert.Equal(t, []Stage{
+			{Action: StageActionCompress, Age: Duration(2 * time.Hour)},
+			{Action: StageActionDelete, Age: Duration(7 * 24 * time.Hour)},
+		}, actual.Rules[0].Stages)
+	})
+	t.Run("should fail on invalid duration", func(t *testing.T) {
+		file := filepath.Join(dir, "invalid.yaml")
+		_ = ioutil.WriteFile(file, []byte("rules:\n  - path: a\n    maxAge: soon\n"), 0644)
+
+		// when
+		_, err := LoadPolicy(file)
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid duration "soon"`)
+	})
+	t.Run("should fail on misspelled field", func(t *testing.T) {
+		file := filepath.Join(dir, "misspelled.yaml")
+		_ = ioutil.WriteFile(file, []byte("rules:\n  - path: export\n    max_age: 2d\n"), 0644)
+
+		// when
+		_, err := LoadPolicy(file)
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "field max_age not found")
+	})
+	t.Run("should load empty policy", func(t *testing.T) {
+		file := filepath.Join(dir, "empty.yaml")
+		_ = ioutil.WriteFile(file, []byte(""), 0644)
+
+		// when
+		actual, err := LoadPolicy(file)
+
+		// then
+		require.NoError(t, err)
+		assert.Empty(t, actual.Rules)
+	})
+	t.Run("should fail on missing file", func(t *testing.T) {
+		_, err := LoadPolicy(filepath.Join(dir, "missing.yaml"))
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not read policy file")
+	})
+}
+
+func Test_compileRules(t *testing.T) {
+	t.Run("should only create root rule without policy", func(t *testing.T) {
+		actual, err := compileRules(Args{MaxAge: time.Hour}, nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, []*compiledRule{{path: ".", maxAge: time.Hour, deleteEmptyDirectories: true}}, actual)
+	})
+	t.Run("should inherit unset settings", func(t *testing.T) {
+		policy := &Policy{Rules: []Rule{{Path: "/upload/"}}}
+
+		actual, err := compileRules(Args{MaxAge: time.Hour, MinDirectoryAge: time.Minute}, policy)
+
+		require.NoError(t, err)
+		require.Len(t, actual, 2)
+		assert.Equal(t, "upload", actual[1].path)
+		assert.Equal(t, time.Hour, actual[1].maxAge)
+		assert.Equal(t, time.Minute, actual[1].minDirectoryAge)
+		assert.True(t, actual[1].deleteEmptyDirectories)
+	})
+	t.Run("should override the min directory age", func(t *testing.T) {
+		minDirectoryAge := Duration(10 * time.Minute)
+		policy := &Policy{Rules: []Rule{{Path: "upload", MinDirectoryAge: &minDirectoryAge}}}
+
+		actual, err := compileRules(Args{MinDirectoryAge: time.Minute}, policy)
+
+		require.NoError(t, err)
+		require.Len(t, actual, 2)
+		assert.Equal(t, time.Minute, actual[0].minDirectoryAge)
+		assert.Equal(t, 10*time.Minute, actual[1].minDirectoryAge)
+	})
+	t.Run("should override the stages", func(t *testing.T) {
+		maxAge := Duration(time.Hour)
+		stages := []Stage{{Action: StageActionCompress, Age: Duration(time.Hour)}}
+		policy := &Policy{Rules: []Rule{{Path: "logs", Stages: stages}, {Path: "upload", MaxAge: &maxAge}}}
+		rootStages := []Stage{{Action: StageActionDelete, Age: Duration(day)}}
+
+		actual, err := compileRules(Args{Stages: rootStages}, policy)
+
+		require.NoError(t, err)
+		require.Len(t, actual, 3)
+		assert.Equal(t, rootStages, actual[0].stages)
+		assert.Equal(t, stages, actual[1].stages)
+		assert.Nil(t, actual[2].stages)
+		assert.Equal(t, time.Hour, actual[2].maxAge)
+	})
+	t.Run("should fail on max age together with stages", func(t *testing.T) {
+		maxAge := Duration(time.Hour)
+		stages := []Stage{{Action: StageActionDelete, Age: Duration(day)}}
+
+		_, err := compileRules(Args{}, &Policy{Rules: []Rule{{Path: "a", MaxAge: &maxAge, Stages: stages}}})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "maxAge and stages cannot be used together")
+	})
+	for _, invalidPath := range []string{"", "/", ".", "..", "../other"} {
+		t.Run("should fail on rule path "+invalidPath, func(t *testing.T) {
+			_, err := compileRules(Args{}, &Policy{Rules: []Rule{{Path: invalidPath}}})
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "must be a subdirectory of the start directory")
+		})
+	}
+	t.Run("should fail on duplicate rule paths", func(t *testing.T) {
+		_, err := compileRules(Args{}, &Policy{Rules: []Rule{{Path: "a"}, {Path: "a/"}}})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "path is configured more than once")
+	})
+	t.Run("should fail on invalid patterns", func(t *testing.T) {
+		_, err := compileRules(Args{}, &Policy{Rules: []Rule{{Path: "a", Exclude: []string{"[a"}}}})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `exclude patterns of rule "a" are invalid`)
+	})
+}
+
+func Test_deleter_ruleFor(t *testing.T) {
+	policy := &Policy{Rules: []Rule{{Path: "export"}, {Path: "export/pdf"}, {Path: "upload"}}}
+	sut, err := New(Args{Directory: "/tmp", Policy: policy})
+	require.NoError(t, err)
+
+	assert.Equal(t, ".", sut.ruleFor("file").path)
+	assert.Equal(t, ".", sut.ruleFor("export").path)
+	assert.Equal(t, "export", sut.ruleFor("export/file").path)
+	assert.Equal(t, "export", sut.ruleFor("export/pdf").path)
+	assert.Equal(t, "export/pdf", sut.ruleFor("export/pdf/file").path)
+	assert.Equal(t, ".", sut.ruleFor("exports/file").path)
+	assert.Equal(t, "upload", sut.ruleFor("upload/a/b/c").path)
+}
+
+func Test_deleter_Execute_withPolicy(t *testing.T) {
+	t.Run("should apply the most specific rule", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		uploadDir := filepath.Join(startDir, "upload")
+		exportDir := filepath.Join(startDir, "export")
+		_ = os.MkdirAll(filepath.Join(exportDir, "empty"), 0755)
+		_ = os.MkdirAll(uploadDir, 0755)
+		now := nowClock.Now()
+
+		deleteFile1 := createFileWithTime(t, uploadDir, "a-del-file", now.Add(-1*time.Hour))
+		leaveFile1 := createFileWithTime(t, exportDir, "b-stay-file", now.Add(-20*time.Hour))
+		deleteFile2 := createFileWithTime(t, exportDir, "c-del-file", now.Add(-50*time.Hour))
+		leaveFile2 := createFileWithTime(t, exportDir, "d-stay-file.lock", now.Add(-50*time.Hour))
+		leaveFile3 := createFileWithTime(t, startDir, "e-stay-file", now.Add(-1*time.Hour))
+
+		maxAgeUpload := Duration(30 * time.Minute)
+		maxAgeExport := Duration(48 * time.Hour)
+		keepDirectories := false
+		policy := &Policy{Rules: []Rule{
+			{Path: "upload", MaxAge: &maxAgeUpload},
+			{Path: "export", MaxAge: &maxAgeExport, Exclude: []string{"*.lock*"}, DeleteEmptyDirectories: &keepDirectories},
+		}}
+		sut, err := New(Args{Directory: startDir, MaxAge: testMaxAge, Policy: policy})
+		require.NoError(t, err)
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		// the emptied upload directory belongs to the root rule and is deleted, too
+		assert.Equal(t, 3, actual.deleted)
+		assertFileNotExists(t, uploadDir)
+		assertFileNotExists(t, deleteFile1)
+		assertFileNotExists(t, deleteFile2)
+		assertFileExists(t, leaveFile1)
+		assertFileExists(t, leaveFile2)
+		assertFileExists(t, leaveFile3)
+		assertFileExists(t, filepath.Join(exportDir, "empty"))
+	})
+}
@@ -0,0 +1,337 @@
+package deletion
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	errors2 "github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// quarantineRunIDFormat names the directory of each deletion run inside the quarantine directory. The names sort
+	// chronologically.
+	quarantineRunIDFormat = "20060102T150405.000Z"
+	// quarantineFilesDirectory contains the moved files of a run below their path relative to the start directory.
+	quarantineFilesDirectory = "files"
+	// quarantineManifestFile records the original location of every moved file of a run, one JSON object per line.
+	quarantineManifestFile = "manifest.jsonl"
+)
+
+// QuarantineEntry describes a file that was moved into the quarantine directory.
+type QuarantineEntry struct {
+	// OriginalPath contains the absolute path from which the file was moved.
+	OriginalPath string `json:"originalPath"`
+	// QuarantinePath contains the path of the moved file relative to the run's files directory.
+	QuarantinePath string    `json:"quarantinePath"`
+	Size           int64     `json:"size"`
+	ModTime        time.Time `json:"mtime"`
+	QuarantinedAt  time.Time `json:"quarantinedAt"`
+}
+
+// QuarantineRun summarizes the files of a deletion run that are still kept in the quarantine directory.
+type QuarantineRun struct {
+	ID    string
+	Time  time.Time
+	Files int
+	Bytes int64
+}
+
+// RestoreResults describes the outcome of restoring quarantined files.
+type RestoreResults struct {
+	Restored int
+	Failures []Failure
+}
+
+// quarantineRemover moves files into a run directory below the quarantine directory instead of deleting them. Empty
+// directories are deleted because they are recreated on restore. It may be used by several goroutines at once.
+type quarantineRemover struct {
+	startDirectory string
+	runDirectory   string
+
+	mutex sync.Mutex
+	// manifest is opened with the first moved file so that runs without deletions leave no traces.
+	manifest *os.File
+}
+
+func newQuarantineRemover(quarantineDirectory, startDirectory string, runTime time.Time) *quarantineRemover {
+	return &quarantineRemover{
+		startDirectory: startDirectory,
+		runDirectory:   filepath.Join(quarantineDirectory, runTime.UTC().Format(quarantineRunIDFormat)),
+	}
+}
+
+// Remove moves the given file into the quarantine directory and records it in the manifest. The entry is recorded
+// first so that no quarantined file is ever missing in the manifest.
+func (q *quarantineRemover) Remove(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return os.Remove(path)
+	}
+
+	relPath, err := filepath.Rel(q.startDirectory, path)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(q.runDirectory, quarantineFilesDirectory, relPath)
+	originalPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	err = q.record(QuarantineEntry{
+		OriginalPath:   originalPath,
+		QuarantinePath: filepath.ToSlash(relPath),
+		Size:           info.Size(),
+		ModTime:        info.ModTime(),
+		QuarantinedAt:  nowClock.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), 0750)
+	if err != nil {
+		return errors2.Wrapf(err, "could not create quarantine directory for %q", path)
+	}
+
+	err = os.Rename(path, target)
+	if errors.Is(err, syscall.EXDEV) {
+		return errors2.Wrapf(err, "could not move %q into quarantine: the quarantine directory must be on the same "+
+			"filesystem as the start directory", path)
+	}
+	return err
+}
+
+func (q *quarantineRemover) record(entry QuarantineEntry) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.manifest == nil {
+		err := os.MkdirAll(q.runDirectory, 0750)
+		if err != nil {
+			return errors2.Wrapf(err, "could not create quarantine run directory %q", q.runDirectory)
+		}
+		q.manifest, err = os.OpenFile(filepath.Join(q.runDirectory, quarantineManifestFile),
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return errors2.Wrapf(err, "could not open quarantine manifest in %q", q.runDirectory)
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = q.manifest.Write(append(line, '\n'))
+	if err != nil {
+		return errors2.Wrapf(err, "could not write quarantine manifest in %q", q.runDirectory)
+	}
+
+	return nil
+}
+
+// close closes the manifest if any file was moved.
+func (q *quarantineRemover) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.manifest != nil {
+		_ = q.manifest.Close()
+		q.manifest = nil
+	}
+}
+
+// purgeQuarantine deletes all quarantined runs that are older than the given retention. Runs that cannot be deleted
+// are logged and retried with the next deletion run.
+func purgeQuarantine(quarantineDirectory string, retention time.Duration) {
+	runIDs, err := quarantineRunIDs(quarantineDirectory)
+	if err != nil {
+		log.Warningf("could not read quarantine directory: %v", err)
+		return
+	}
+
+	for _, runID := range runIDs {
+		runTime, _ := time.Parse(quarantineRunIDFormat, runID)
+		if !fileOlderThan(retention, runTime) {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(quarantineDirectory, runID))
+		if err != nil {
+			log.Warningf("could not purge quarantined run %s: %v", runID, err)
+			continue
+		}
+		log.Infof("purged quarantined run %s", runID)
+	}
+}
+
+// quarantineRunIDs returns the IDs of all runs in the quarantine directory, oldest first. Other entries are ignored. A
+// missing quarantine directory contains no runs.
+func quarantineRunIDs(quarantineDirectory string) ([]string, error) {
+	entries, err := os.ReadDir(quarantineDirectory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var runIDs []string
+	for _, entry := range entries {
+		if _, err := time.Parse(quarantineRunIDFormat, entry.Name()); err == nil && entry.IsDir() {
+			runIDs = append(runIDs, entry.Name())
+		}
+	}
+	sort.Strings(runIDs)
+
+	return runIDs, nil
+}
+
+// readQuarantineManifest returns the entries of the given run whose files are still kept in quarantine.
+func readQuarantineManifest(quarantineDirectory, runID string) ([]QuarantineEntry, error) {
+	runDirectory := filepath.Join(quarantineDirectory, runID)
+	manifest, err := os.Open(filepath.Join(runDirectory, quarantineManifestFile))
+	if err != nil {
+		return nil, errors2.Wrapf(err, "could not open quarantine manifest of run %s", runID)
+	}
+	defer func() { _ = manifest.Close() }()
+
+	var entries []QuarantineEntry
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		entry := QuarantineEntry{}
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, errors2.Wrapf(err, "could not parse quarantine manifest of run %s", runID)
+		}
+
+		_, err = os.Lstat(quarantinedFile(quarantineDirectory, runID, entry))
+		if err == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+func quarantinedFile(quarantineDirectory, runID string, entry QuarantineEntry) string {
+	return filepath.Join(quarantineDirectory, runID, quarantineFilesDirectory, filepath.FromSlash(entry.QuarantinePath))
+}
+
+// ListQuarantineRuns returns all runs that still keep files in the given quarantine directory, oldest first.
+func ListQuarantineRuns(quarantineDirectory string) ([]QuarantineRun, error) {
+	runIDs, err := quarantineRunIDs(quarantineDirectory)
+	if err != nil {
+		return nil, errors2.Wrapf(err, "could not read quarantine directory %q", quarantineDirectory)
+	}
+
+	var runs []QuarantineRun
+	for _, runID := range runIDs {
+		entries, err := readQuarantineManifest(quarantineDirectory, runID)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		runTime, _ := time.Parse(quarantineRunIDFormat, runID)
+		run := QuarantineRun{ID: runID, Time: runTime, Files: len(entries)}
+		for _, entry := range entries {
+			run.Bytes += entry.Size
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// Restore moves quarantined files back to their original location. The target is either the ID of a run, which
+// restores all files of that run, or an original path, which restores the newest quarantined version of that file or
+// of all files below that directory. Existing files are never overwritten.
+func Restore(quarantineDirectory, target string) (RestoreResults, error) {
+	runIDs, err := quarantineRunIDs(quarantineDirectory)
+	if err != nil {
+		return RestoreResults{}, errors2.Wrapf(err, "could not read quarantine directory %q", quarantineDirectory)
+	}
+
+	restoreRun := false
+	for _, runID := range runIDs {
+		restoreRun = restoreRun || runID == target
+	}
+	targetPath, err := filepath.Abs(target)
+	if err != nil {
+		return RestoreResults{}, err
+	}
+
+	results := RestoreResults{}
+	found := false
+	restoredPaths := map[string]bool{}
+	// walk the newest runs first so that the newest version of a file wins
+	for i := len(runIDs) - 1; i >= 0; i-- {
+		runID := runIDs[i]
+		if restoreRun && runID != target {
+			continue
+		}
+
+		entries, err := readQuarantineManifest(quarantineDirectory, runID)
+		if err != nil {
+			return results, err
+		}
+
+		for _, entry := range entries {
+			if !restoreRun && entry.OriginalPath != targetPath &&
+				!strings.HasPrefix(entry.OriginalPath, targetPath+string(filepath.Separator)) {
+				continue
+			}
+			if restoredPaths[entry.OriginalPath] {
+				continue
+			}
+			found = true
+			restoredPaths[entry.OriginalPath] = true
+
+			err = restoreEntry(quarantineDirectory, runID, entry)
+			if err != nil {
+				log.Warningf("could not restore %s: %v", entry.OriginalPath, err)
+				results.Failures = append(results.Failures, Failure{Path: entry.OriginalPath, Err: err})
+				continue
+			}
+			log.Debugf("restored: %s", entry.OriginalPath)
+			results.Restored++
+		}
+	}
+
+	if !found {
+		return results, fmt.Errorf("no quarantined files found for %q", target)
+	}
+
+	return results, nil
+}
+
+func restoreEntry(quarantineDirectory, runID string, entry QuarantineEntry) error {
+	_, err := os.Lstat(entry.OriginalPath)
+	if err == nil {
+		return errors.New("a file with the same name already exists")
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(entry.OriginalPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(quarantinedFile(quarantineDirectory, runID, entry), entry.OriginalPath)
+}
@@ -0,0 +1,178 @@
+package deletion
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_deleter_Execute_withQuarantine(t *testing.T) {
+	t.Run("should move old files into quarantine and record them in the manifest", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		quarantineDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-quarantine-")
+		defer func() { _ = os.RemoveAll(quarantineDir) }()
+		oldTime := nowClock.Now().Add(-20 * time.Hour)
+		exportDir := filepath.Join(startDir, "export")
+		_ = os.MkdirAll(exportDir, 0755)
+		quarantineFile1 := createFileWithSizeAndTime(t, exportDir, "a-old", 42, oldTime)
+		leaveFile1 := createFileWithTime(t, startDir, "b-new", nowClock.Now())
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, QuarantineDirectory: quarantineDir, QuarantineRetention: week})
+
+		// when
+		actual, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 2, actual.deleted)
+		assert.True(t, actual.quarantined)
+		assertFileNotExists(t, quarantineFile1)
+		assertFileNotExists(t, exportDir)
+		assertFileExists(t, leaveFile1)
+
+		runs, err := ListQuarantineRuns(quarantineDir)
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		assert.Equal(t, 1, runs[0].Files)
+		assert.Equal(t, int64(42), runs[0].Bytes)
+
+		entries, err := readQuarantineManifest(quarantineDir, runs[0].ID)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, quarantineFile1, entries[0].OriginalPath)
+		assert.Equal(t, "export/"+filepath.Base(quarantineFile1), entries[0].QuarantinePath)
+		assertFileExists(t, filepath.Join(quarantineDir, runs[0].ID, "files", "export", filepath.Base(quarantineFile1)))
+	})
+	t.Run("should purge quarantined runs after the retention", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		quarantineDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-quarantine-")
+		defer func() { _ = os.RemoveAll(quarantineDir) }()
+		now := nowClock.Now()
+		oldRun := filepath.Join(quarantineDir, now.Add(-8*day).UTC().Format(quarantineRunIDFormat))
+		newRun := filepath.Join(quarantineDir, now.Add(-6*day).UTC().Format(quarantineRunIDFormat))
+		otherDir := filepath.Join(quarantineDir, "other")
+		for _, dir := range []string{oldRun, newRun, otherDir} {
+			require.NoError(t, os.MkdirAll(dir, 0755))
+		}
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, QuarantineDirectory: quarantineDir, QuarantineRetention: week})
+
+		// when
+		_, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assertFileNotExists(t, oldRun)
+		assertFileExists(t, newRun)
+		assertFileExists(t, otherDir)
+	})
+}
+
+func TestRestore(t *testing.T) {
+	quarantineFiles := func(t *testing.T, startDir, quarantineDir string, runTime time.Time, paths ...string) {
+		t.Helper()
+
+		sut := newQuarantineRemover(quarantineDir, startDir, runTime)
+		defer sut.close()
+		for _, path := range paths {
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, ioutil.WriteFile(path, []byte(runTime.String()), 0644))
+			require.NoError(t, sut.Remove(path))
+		}
+	}
+
+	t.Run("should restore a whole run", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		quarantineDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-quarantine-")
+		defer func() { _ = os.RemoveAll(quarantineDir) }()
+		file1 := filepath.Join(startDir, "a", "file1")
+		file2 := filepath.Join(startDir, "b", "c", "file2")
+		runTime := time.Date(2021, 3, 3, 3, 3, 0, 0, time.UTC)
+		quarantineFiles(t, startDir, quarantineDir, runTime, file1, file2)
+
+		// when
+		actual, err := Restore(quarantineDir, runTime.Format(quarantineRunIDFormat))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, RestoreResults{Restored: 2}, actual)
+		assertFileExists(t, file1)
+		assertFileExists(t, file2)
+		runs, err := ListQuarantineRuns(quarantineDir)
+		require.NoError(t, err)
+		assert.Empty(t, runs)
+	})
+	t.Run("should restore the newest version of a path", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		quarantineDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-quarantine-")
+		defer func() { _ = os.RemoveAll(quarantineDir) }()
+		file1 := filepath.Join(startDir, "a", "file1")
+		file2 := filepath.Join(startDir, "b", "file2")
+		oldRunTime := time.Date(2021, 3, 3, 3, 3, 0, 0, time.UTC)
+		newRunTime := oldRunTime.Add(time.Hour)
+		quarantineFiles(t, startDir, quarantineDir, oldRunTime, file1, file2)
+		quarantineFiles(t, startDir, quarantineDir, newRunTime, file1)
+
+		// when
+		actual, err := Restore(quarantineDir, filepath.Join(startDir, "a"))
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, RestoreResults{Restored: 1}, actual)
+		content, _ := ioutil.ReadFile(file1)
+		assert.Equal(t, newRunTime.String(), string(content))
+		assertFileNotExists(t, file2)
+	})
+	t.Run("should not overwrite existing files", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		quarantineDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-quarantine-")
+		defer func() { _ = os.RemoveAll(quarantineDir) }()
+		file1 := filepath.Join(startDir, "file1")
+		quarantineFiles(t, startDir, quarantineDir, time.Date(2021, 3, 3, 3, 3, 0, 0, time.UTC), file1)
+		require.NoError(t, ioutil.WriteFile(file1, []byte("new"), 0644))
+
+		// when
+		actual, err := Restore(quarantineDir, file1)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 0, actual.Restored)
+		require.Len(t, actual.Failures, 1)
+		assert.Contains(t, actual.Failures[0].Err.Error(), "already exists")
+		content, _ := ioutil.ReadFile(file1)
+		assert.Equal(t, "new", string(content))
+	})
+	t.Run("should fail for unknown paths", func(t *testing.T) {
+		quarantineDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-quarantine-")
+		defer func() { _ = os.RemoveAll(quarantineDir) }()
+
+		// when
+		_, err := Restore(quarantineDir, "/opt/unknown")
+
+		// then
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `no quarantined files found for "/opt/unknown"`)
+	})
+}
+
+func TestIsBelow(t *testing.T) {
+	assert.True(t, IsBelow("/tmp/a/b", "/tmp/a"))
+	assert.True(t, IsBelow("/tmp/a", "/tmp/a"))
+	assert.False(t, IsBelow("/tmp/ab", "/tmp/a"))
+	assert.False(t, IsBelow("/tmp", "/tmp/a"))
+}
@@ -0,0 +1,125 @@
+package deletion
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type quotaCandidate struct {
+	path     string
+	info     os.FileInfo
+	fileTime time.Time
+	// compressed marks a file that a stage has just compressed. The walk counted it as compressed instead of skipped.
+	compressed bool
+}
+
+// addQuotaCandidate remembers a selected file that remains after the age-based deletion.
+func (d *deleter) addQuotaCandidate(path string, info os.FileInfo) {
+	d.addToQuota(quotaCandidate{path: path, info: info, fileTime: fileTime(d.TimeSource, path, info)})
+}
+
+// addCompressedQuotaCandidate remembers a file that a stage has just compressed.
+func (d *deleter) addCompressedQuotaCandidate(path string, info os.FileInfo) {
+	d.addToQuota(quotaCandidate{path: path, info: info, fileTime: fileTime(d.TimeSource, path, info), compressed: true})
+}
+
+func (d *deleter) addToQuota(candidate quotaCandidate) {
+	if d.MaxSizeInBytes <= 0 {
+		return
+	}
+
+	d.quotaMutex.Lock()
+	defer d.quotaMutex.Unlock()
+
+	d.quotaCandidates = append(d.quotaCandidates, candidate)
+	d.quotaSize += candidate.info.Size()
+}
+
+// addQuotaDirectory remembers a directory that was kept because of its remaining entries.
+func (d *deleter) addQuotaDirectory(path string, remaining int) {
+	if d.MaxSizeInBytes <= 0 {
+		return
+	}
+
+	d.quotaMutex.Lock()
+	defer d.quotaMutex.Unlock()
+
+	if d.quotaDirectories == nil {
+		d.quotaDirectories = map[string]int{}
+	}
+	d.quotaDirectories[path] = remaining
+}
+
+// enforceQuota deletes the oldest remaining files until the total size of all selected files in the start directory
+// no longer exceeds the size budget. Directories that are emptied this way are deleted, too.
+func (d *deleter) enforceQuota(ctx context.Context) error {
+	totalSize := d.quotaSize
+	if totalSize <= d.MaxSizeInBytes {
+		log.Debugf("quota: %d bytes are within the size budget of %d bytes", totalSize, d.MaxSizeInBytes)
+		return nil
+	}
+
+	candidates := d.quotaCandidates
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].fileTime.Before(candidates[j].fileTime)
+	})
+
+	for _, candidate := range candidates {
+		if totalSize <= d.MaxSizeInBytes || ctx.Err() != nil {
+			break
+		}
+
+		pass := d.Results.passQuota
+		if candidate.compressed {
+			pass = d.Results.passQuotaCompressed
+		}
+		removed, err := d.removePath(candidate.path, candidate.info, pass)
+		if err != nil {
+			return err
+		}
+		if removed {
+			totalSize -= candidate.info.Size()
+			err = d.removeEmptiedParent(candidate.path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// removeEmptiedParent deletes the parent directory of the given removed path if nothing is left in it, and so on
+// upwards. Like in the walk, the parent must be old enough after the removal of its entries.
+func (d *deleter) removeEmptiedParent(path string) error {
+	parent := filepath.Dir(path)
+	remaining, ok := d.quotaDirectories[parent]
+	if !ok {
+		return nil
+	}
+	d.quotaDirectories[parent] = remaining - 1
+	if remaining > 1 {
+		return nil
+	}
+
+	info, err := os.Lstat(parent)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return d.visitFailed(parent, err)
+	}
+	if !d.directoryOlderThan(d.ruleFor(d.relativePath(parent)).minDirectoryAge, parent, info, true) {
+		return nil
+	}
+
+	removed, err := d.removePath(parent, info, d.Results.passEmptied)
+	if err != nil || !removed {
+		return err
+	}
+
+	return d.removeEmptiedParent(parent)
+}
@@ -0,0 +1,134 @@
+package deletion
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_deleter_Execute_withSizeBudget(t *testing.T) {
+	t.Run("should delete oldest files until the size budget is met", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		now := nowClock.Now()
+		oldest := createFileWithSizeAndTime(t, startDir, "c-oldest", 3000, now.Add(-3*time.Hour))
+		older := createFileWithSizeAndTime(t, startDir, "a-older", 3000, now.Add(-2*time.Hour))
+		newest := createFileWithSizeAndTime(t, startDir, "b-newest", 3000, now.Add(-1*time.Hour))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MaxSizeInBytes: 5000})
+
+		// when
+		_, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 2, sut.Results.deleted)
+		assert.Equal(t, 2, sut.Results.deletedByQuota)
+		assert.Equal(t, 1, sut.Results.skipped)
+		assertFileNotExists(t, oldest)
+		assertFileNotExists(t, older)
+		assertFileExists(t, newest)
+	})
+	t.Run("should delete directories emptied by the size budget", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		now := nowClock.Now()
+		outerDir := filepath.Join(startDir, "a-outer")
+		innerDir := filepath.Join(outerDir, "inner")
+		require.NoError(t, os.MkdirAll(innerDir, 0755))
+		oldest := createFileWithSizeAndTime(t, innerDir, "a-oldest", 3000, now.Add(-3*time.Hour))
+		stayDir := filepath.Join(startDir, "b-stay")
+		require.NoError(t, os.Mkdir(stayDir, 0755))
+		newest := createFileWithSizeAndTime(t, stayDir, "a-newest", 3000, now.Add(-1*time.Hour))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MaxSizeInBytes: 5000})
+
+		// when
+		_, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 3, sut.Results.deleted)
+		assert.Equal(t, 1, sut.Results.deletedByQuota)
+		assert.Equal(t, 2, sut.Results.skipped)
+		assertFileNotExists(t, oldest)
+		assertFileNotExists(t, innerDir)
+		assertFileNotExists(t, outerDir)
+		assertFileExists(t, newest)
+		assertFileExists(t, stayDir)
+	})
+	t.Run("should keep emptied directories that are too young", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		now := nowClock.Now()
+		dir := filepath.Join(startDir, "a-dir")
+		require.NoError(t, os.Mkdir(dir, 0755))
+		oldest := createFileWithSizeAndTime(t, dir, "a-oldest", 3000, now.Add(-3*time.Hour))
+		newest := createFileWithSizeAndTime(t, startDir, "b-newest", 3000, now.Add(-1*time.Hour))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MaxSizeInBytes: 5000, MinDirectoryAge: time.Hour})
+
+		// when
+		_, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assertFileNotExists(t, oldest)
+		assertFileExists(t, dir)
+		assertFileExists(t, newest)
+	})
+	t.Run("should not delete anything within the size budget", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		file := createFileWithSizeAndTime(t, startDir, "a", 3000, nowClock.Now().Add(-3*time.Hour))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MaxSizeInBytes: 3000})
+
+		// when
+		_, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 0, sut.Results.deleted)
+		assertFileExists(t, file)
+	})
+	t.Run("should ignore excluded files", func(t *testing.T) {
+		// given
+		startDir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+		defer func() { _ = os.RemoveAll(startDir) }()
+		_ = os.Mkdir(filepath.Join(startDir, "lucene"), 0755)
+		protected := createFileWithSizeAndTime(t, filepath.Join(startDir, "lucene"), "a", 9000, nowClock.Now().Add(-5*time.Hour))
+		file := createFileWithSizeAndTime(t, startDir, "b", 3000, nowClock.Now().Add(-3*time.Hour))
+
+		sut, _ := New(Args{Directory: startDir, MaxAge: testMaxAge, MaxSizeInBytes: 3000, Exclude: []string{"lucene"}})
+
+		// when
+		_, err := sut.Execute(context.Background())
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 0, sut.Results.deleted)
+		assertFileExists(t, protected)
+		assertFileExists(t, file)
+	})
+}
+
+func createFileWithSizeAndTime(t *testing.T, directory string, filenamePrefix string, size int, fileTime time.Time) string {
+	t.Helper()
+
+	file := createFileWithTime(t, directory, filenamePrefix, fileTime)
+	writeBytesToFile(t, file, size)
+	err := os.Chtimes(file, fileTime, fileTime)
+	require.NoError(t, err)
+
+	return file
+}
@@ -0,0 +1,102 @@
+package deletion
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Report is the machine-readable form of the results of a single deletion run.
+type Report struct {
+	Directory       string    `json:"directory"`
+	DryRun          bool      `json:"dryRun"`
+	Interrupted     bool      `json:"interrupted"`
+	StartTime       time.Time `json:"startTime"`
+	EndTime         time.Time `json:"endTime"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	// Deleted counts the deleted files and directories. In dry-run mode, it counts the paths that would be deleted.
+	Deleted             int   `json:"deleted"`
+	DeletedBytes        int64 `json:"deletedBytes"`
+	DeletedByQuota      int   `json:"deletedByQuota"`
+	DeletedByQuotaBytes int64 `json:"deletedByQuotaBytes"`
+	// Compressed counts the files that were compressed by a stage. CompressedBytes sums up their sizes before the
+	// compression.
+	Compressed            int   `json:"compressed"`
+	CompressedBytes       int64 `json:"compressedBytes"`
+	CompressionSavedBytes int64 `json:"compressionSavedBytes"`
+	Skipped               int   `json:"skipped"`
+	InUse                 int   `json:"inUse"`
+	ChangedDuringRun      int   `json:"changedDuringRun"`
+	Failed                int   `json:"failed"`
+	// RemainingBytes sums up the sizes of the files that are left in the walked part of the start directory.
+	RemainingBytes int64 `json:"remainingBytes"`
+	// ArchiveFile names the bundle into which the deleted files were packed in archive mode.
+	ArchiveFile string `json:"archiveFile,omitempty"`
+	// Failures is never nil so that it is always written as array.
+	Failures        []ReportFailure  `json:"failures"`
+	DiskSpaceBefore *ReportDiskSpace `json:"diskSpaceBefore,omitempty"`
+	DiskSpaceAfter  *ReportDiskSpace `json:"diskSpaceAfter,omitempty"`
+}
+
+// ReportFailure describes a path that could not be visited or deleted.
+type ReportFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ReportDiskSpace describes the capacity of the start directory's filesystem.
+type ReportDiskSpace struct {
+	FreeBytes   uint64  `json:"freeBytes"`
+	TotalBytes  uint64  `json:"totalBytes"`
+	FreePercent float64 `json:"freePercent"`
+}
+
+// Report returns the results in machine-readable form.
+func (r *Results) Report() Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := Report{
+		Directory:             r.directory,
+		DryRun:                r.dryRun,
+		Interrupted:           r.interrupted,
+		StartTime:             r.startTime,
+		EndTime:               r.endTime,
+		DurationSeconds:       r.endTime.Sub(r.startTime).Seconds(),
+		Deleted:               r.deleted,
+		DeletedBytes:          r.deletedBytes,
+		DeletedByQuota:        r.deletedByQuota,
+		DeletedByQuotaBytes:   r.deletedByQuotaBytes,
+		Compressed:            r.compressed,
+		CompressedBytes:       r.compressedBytes,
+		CompressionSavedBytes: r.compressionSavedBytes,
+		Skipped:               r.skipped,
+		InUse:                 r.inUse,
+		ChangedDuringRun:      r.changed,
+		Failed:                r.failed,
+		RemainingBytes:        r.remainingBytes,
+		ArchiveFile:           r.archiveFile,
+		Failures:              []ReportFailure{},
+		DiskSpaceBefore:       newReportDiskSpace(r.diskSpaceBefore),
+		DiskSpaceAfter:        newReportDiskSpace(r.diskSpaceAfter),
+	}
+	for _, failure := range r.failures {
+		report.Failures = append(report.Failures, ReportFailure{Path: failure.Path, Error: failure.Err.Error()})
+	}
+
+	return report
+}
+
+// WriteJSON writes the report of the results as a single line of JSON. Several reports written to the same file
+// form a JSON Lines file.
+func (r *Results) WriteJSON(writer io.Writer) error {
+	return json.NewEncoder(writer).Encode(r.Report())
+}
+
+func newReportDiskSpace(space *DiskSpace) *ReportDiskSpace {
+	if space == nil {
+		return nil
+	}
+
+	return &ReportDiskSpace{FreeBytes: space.FreeBytes, TotalBytes: space.TotalBytes, FreePercent: space.FreePercent()}
+}
@@ -0,0 +1,81 @@
+package deletion
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResults_Report(t *testing.T) {
+	t.Run("should convert all counters", func(t *testing.T) {
+		startTime := time.Date(2021, 3, 3, 3, 3, 0, 0, time.UTC)
+		sut := &Results{
+			directory:           "/tmp",
+			startTime:           startTime,
+			endTime:             startTime.Add(1500 * time.Millisecond),
+			deleted:             3,
+			deletedBytes:        1234,
+			deletedByQuota:      1,
+			deletedByQuotaBytes: 1000,
+			skipped:             4,
+			inUse:               1,
+			changed:             2,
+			failed:              1,
+			remainingBytes:      4096,
+			failures:            []Failure{{Path: "/tmp/a", Err: errors.New("permission denied")}},
+			diskSpaceBefore:     &DiskSpace{FreeBytes: 25, TotalBytes: 100},
+		}
+
+		// when
+		actual := sut.Report()
+
+		// then
+		expected := Report{
+			Directory:           "/tmp",
+			StartTime:           startTime,
+			EndTime:             startTime.Add(1500 * time.Millisecond),
+			DurationSeconds:     1.5,
+			Deleted:             3,
+			DeletedBytes:        1234,
+			DeletedByQuota:      1,
+			DeletedByQuotaBytes: 1000,
+			Skipped:             4,
+			InUse:               1,
+			ChangedDuringRun:    2,
+			Failed:              1,
+			RemainingBytes:      4096,
+			Failures:            []ReportFailure{{Path: "/tmp/a", Error: "permission denied"}},
+			DiskSpaceBefore:     &ReportDiskSpace{FreeBytes: 25, TotalBytes: 100, FreePercent: 25},
+		}
+		assert.Equal(t, expected, actual)
+	})
+}
+
+func TestResults_WriteJSON(t *testing.T) {
+	t.Run("should write a single line", func(t *testing.T) {
+		sut := &Results{directory: "/tmp", dryRun: true, deleted: 2, deletedBytes: 42}
+		buffer := &bytes.Buffer{}
+
+		// when
+		err := sut.WriteJSON(buffer)
+
+		// then
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(buffer.String(), "\n"))
+		assert.True(t, strings.HasSuffix(buffer.String(), "\n"))
+
+		var actual map[string]interface{}
+		require.NoError(t, json.Unmarshal(buffer.Bytes(), &actual))
+		assert.Equal(t, "/tmp", actual["directory"])
+		assert.Equal(t, true, actual["dryRun"])
+		assert.Equal(t, float64(2), actual["deleted"])
+		assert.Equal(t, float64(42), actual["deletedBytes"])
+		assert.Equal(t, []interface{}{}, actual["failures"])
+		assert.NotContains(t, actual, "diskSpaceBefore")
+	})
+}
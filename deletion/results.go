@@ -3,36 +3,336 @@ package deletion
 import (
 	"fmt"
 	"os"
+	"sync"
+	"time"
 )
 
-// Results keeps statistics about the deletion process.
+// Failure describes a file or directory that could not be visited or deleted.
+type Failure struct {
+	Path string
+	Err  error
+}
+
+// Results keeps statistics about the deletion process. It may be updated by several goroutines at once.
 type Results struct {
+	mutex sync.Mutex
+
+	// directory names the start directory of the deletion run.
+	directory string
+	// startTime and endTime frame the deletion run.
+	startTime time.Time
+	endTime   time.Time
+
 	deleted       int
 	deletedSizeKB int64
-	failed        int
-	skipped       int
+	// deletedBytes sums up the exact sizes of the deleted files, in contrast to the floored deletedSizeKB.
+	deletedBytes int64
+	failed       int
+	skipped      int
+	// failures contains every failed path together with its error.
+	failures []Failure
+	// inUse counts files that were not deleted because a process held them open.
+	inUse int
+	// changed counts files that were not deleted because they changed between their inspection and their deletion.
+	changed int
+	// deletedByQuota counts the part of the deleted files that were deleted to meet the size budget.
+	deletedByQuota       int
+	deletedByQuotaSizeKB int64
+	deletedByQuotaBytes  int64
+	// compressed counts the files that were compressed by a stage. compressedBytes sums up their sizes before and
+	// compressionSavedBytes the space freed by their compression.
+	compressed            int
+	compressedBytes       int64
+	compressionSavedBytes int64
+	// remainingBytes sums up the sizes of the walked files that were not deleted.
+	remainingBytes int64
+	// diskSpaceBefore and diskSpaceAfter contain the disk space of the start directory's filesystem before and after
+	// the deletion run. They stay nil if the disk space could not be determined.
+	diskSpaceBefore *DiskSpace
+	diskSpaceAfter  *DiskSpace
+	// dryRun labels the results as hypothetical because nothing was actually deleted.
+	dryRun bool
+	// openFileCheck adds the number of files in use to the statistics.
+	openFileCheck bool
+	// quotaEnabled adds the freed sizes by age and by size budget to the statistics.
+	quotaEnabled bool
+	// compression adds the compressed files to the statistics.
+	compression bool
+	// quarantined labels the deleted files as moved into the quarantine directory.
+	quarantined bool
+	// archiveFile names the bundle into which the deleted files were packed before their deletion.
+	archiveFile string
+	// interrupted labels the results as incomplete because the run was stopped early.
+	interrupted bool
+	// audit optionally records every deleted and failed path.
+	audit *auditLog
 }
 
 // PrintStats prints deletion statistics as one-liner.
 func (r *Results) PrintStats() {
-	sizeMB := r.deletedSizeKB / 1024
-	fmt.Printf("[tempdel] deleted: %d (%d MB), skipped: %d, failed: %d\n", r.deleted, sizeMB, r.skipped, r.failed)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sizeStats := fmt.Sprintf("%d MB", r.deletedSizeKB/1024)
+	if r.quotaEnabled {
+		ageSizeMB := (r.deletedSizeKB - r.deletedByQuotaSizeKB) / 1024
+		quotaSizeMB := r.deletedByQuotaSizeKB / 1024
+		sizeStats += fmt.Sprintf(", by age: %d MB, by size budget: %d MB", ageSizeMB, quotaSizeMB)
+	}
+
+	skipStats := fmt.Sprintf("%d", r.skipped)
+	if r.openFileCheck {
+		skipStats += fmt.Sprintf(", in use: %d", r.inUse)
+	}
+	if r.changed > 0 {
+		skipStats += fmt.Sprintf(", changed during run: %d", r.changed)
+	}
+
+	if r.dryRun {
+		fmt.Printf("[tempdel] dry-run: would delete: %d (%s), skipped: %s, failed: %d\n", r.deleted, sizeStats, skipStats, r.failed)
+	} else if r.quarantined {
+		fmt.Printf("[tempdel] quarantined: %d (%s), skipped: %s, failed: %d\n", r.deleted, sizeStats, skipStats, r.failed)
+	} else {
+		fmt.Printf("[tempdel] deleted: %d (%s), skipped: %s, failed: %d\n", r.deleted, sizeStats, skipStats, r.failed)
+	}
+
+	if r.compression && r.dryRun {
+		fmt.Printf("[tempdel] dry-run: would compress: %d (%d MB)\n", r.compressed, r.compressedBytes/1024/1024)
+	} else if r.compression {
+		fmt.Printf("[tempdel] compressed: %d (%d MB, saved: %d MB)\n", r.compressed, r.compressedBytes/1024/1024,
+			r.compressionSavedBytes/1024/1024)
+	}
+
+	if r.archiveFile != "" {
+		fmt.Printf("[tempdel] archived deleted files in %s\n", r.archiveFile)
+	}
+
+	if r.interrupted {
+		fmt.Println("[tempdel] the deletion run was interrupted, so the statistics are incomplete")
+	}
+
+	if r.diskSpaceBefore != nil && r.diskSpaceAfter != nil {
+		fmt.Printf("[tempdel] free disk space: before: %d MB (%.1f%%), after: %d MB (%.1f%%)\n",
+			r.diskSpaceBefore.FreeBytes/1024/1024, r.diskSpaceBefore.FreePercent(),
+			r.diskSpaceAfter.FreeBytes/1024/1024, r.diskSpaceAfter.FreePercent())
+	}
+}
+
+// DiskSpaceBefore returns the disk space of the start directory's filesystem before the deletion run. It returns
+// false if the disk space could not be determined.
+func (r *Results) DiskSpaceBefore() (DiskSpace, bool) {
+	if r.diskSpaceBefore == nil {
+		return DiskSpace{}, false
+	}
+	return *r.diskSpaceBefore, true
+}
+
+// DiskSpaceAfter returns the disk space of the start directory's filesystem after the deletion run. It returns
+// false if the disk space could not be determined.
+func (r *Results) DiskSpaceAfter() (DiskSpace, bool) {
+	if r.diskSpaceAfter == nil {
+		return DiskSpace{}, false
+	}
+	return *r.diskSpaceAfter, true
+}
+
+// Failed returns the number of files and directories that could not be deleted.
+func (r *Results) Failed() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.failed
+}
+
+// Failures returns the files and directories that could not be visited or deleted, in the order they failed.
+func (r *Results) Failures() []Failure {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]Failure(nil), r.failures...)
 }
 
-func (r *Results) fail(path string, err error) {
-	log.Debugf("failed: %s with error '%v'", path, err)
+// fail records a failed path and returns the number of failed paths so far.
+func (r *Results) fail(path string, err error) int {
+	r.audit.recordFailure(path, err)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	log.Warningf("failed: %s with error '%v'", path, err)
 	r.failed++
+	r.failures = append(r.failures, Failure{Path: path, Err: err})
+
+	return r.failed
 }
 
-func (r *Results) pass(path string, info os.FileInfo) {
+// pass counts a path that was deleted because it was old enough. The checksum of the file's content is optional.
+func (r *Results) pass(path string, info os.FileInfo, checksum string) {
+	r.audit.record(path, info, r.deletedOutcome(), AuditReasonAge, nil, checksum)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.countPass(path, info)
+}
+
+// countPass counts a deleted path. The caller must hold the mutex.
+func (r *Results) countPass(path string, info os.FileInfo) {
 	sizeKB := info.Size() / 1024
-	log.Debugf("deleted: %s (%d KB)", path, sizeKB)
+	if r.dryRun {
+		fmt.Printf("[tempdel] would delete: %s (%d bytes, modified %s)\n", path, info.Size(), info.ModTime().Format(time.RFC3339))
+	} else {
+		log.Debugf("deleted: %s (%d KB)", path, sizeKB)
+	}
 
 	r.deleted++
 	r.deletedSizeKB += sizeKB
+	r.deletedBytes += info.Size()
+}
+
+// passQuota counts a file that was deleted to meet the size budget. The walk has counted the file as skipped before.
+// The checksum of the file's content is optional.
+func (r *Results) passQuota(path string, info os.FileInfo, checksum string) {
+	r.audit.record(path, info, r.deletedOutcome(), AuditReasonSizeBudget, nil, checksum)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.countPassQuota(path, info)
+	r.skipped--
+}
+
+// passQuotaCompressed counts a file that was compressed by a stage and deleted to meet the size budget afterwards.
+// The checksum of the file's content is optional.
+func (r *Results) passQuotaCompressed(path string, info os.FileInfo, checksum string) {
+	r.audit.record(path, info, r.deletedOutcome(), AuditReasonSizeBudget, nil, checksum)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.countPassQuota(path, info)
+}
+
+// countPassQuota counts a file that was deleted to meet the size budget. The caller must hold the mutex.
+func (r *Results) countPassQuota(path string, info os.FileInfo) {
+	r.countPass(path, info)
+
+	r.deletedByQuota++
+	r.deletedByQuotaSizeKB += info.Size() / 1024
+	r.deletedByQuotaBytes += info.Size()
+	r.remainingBytes -= info.Size()
+}
+
+// passEmptied counts a directory that was deleted because the size budget deleted its last entry. The walk has counted
+// the directory as skipped before.
+func (r *Results) passEmptied(path string, info os.FileInfo, checksum string) {
+	r.audit.record(path, info, r.deletedOutcome(), AuditReasonSizeBudget, nil, checksum)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.countPass(path, info)
+	r.skipped--
+}
+
+// compress counts a file that was replaced by a compressed copy of the given size. In dry-run mode, the compressed
+// size equals the original size.
+func (r *Results) compress(path string, info os.FileInfo, compressedSize int64) {
+	outcome := AuditOutcomeCompressed
+	if r.dryRun {
+		outcome = AuditOutcomeWouldCompress
+	}
+	r.audit.record(path, info, outcome, AuditReasonAge, nil, "")
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.dryRun {
+		fmt.Printf("[tempdel] would compress: %s (%d bytes, modified %s)\n", path, info.Size(), info.ModTime().Format(time.RFC3339))
+	} else {
+		log.Debugf("compressed: %s (%d KB to %d KB)", path, info.Size()/1024, compressedSize/1024)
+	}
+
+	r.compressed++
+	r.compressedBytes += info.Size()
+	r.compressionSavedBytes += info.Size() - compressedSize
+}
+
+// deletedOutcome returns the audit outcome of a deleted path.
+func (r *Results) deletedOutcome() string {
+	if r.dryRun {
+		return AuditOutcomeWouldDelete
+	}
+	if r.quarantined {
+		return AuditOutcomeQuarantined
+	}
+	return AuditOutcomeDeleted
+}
+
+// Interrupted returns true if the deletion run was stopped before it could visit all paths.
+func (r *Results) Interrupted() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.interrupted
+}
+
+func (r *Results) start(startTime time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.startTime = startTime
+}
+
+func (r *Results) finish(endTime time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.endTime = endTime
+}
+
+func (r *Results) archive(archiveFile string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.archiveFile = archiveFile
+}
+
+func (r *Results) interrupt() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.interrupted = true
 }
 
 func (r *Results) skip(path string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	log.Debugf("skipped: %s", path)
 	r.skipped++
 }
+
+// remain counts the size of a file that is left in the start directory.
+func (r *Results) remain(size int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.remainingBytes += size
+}
+
+func (r *Results) skipInUse(path string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	log.Debugf("in use: %s", path)
+	r.inUse++
+}
+
+func (r *Results) skipChanged(path string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	log.Debugf("changed during run: %s", path)
+	r.changed++
+}
@@ -28,12 +28,23 @@ func TestResults_pass(t *testing.T) {
 
 	// when
 	for i := 0; i < 9; i++ {
-		sut.pass(fmt.Sprintf("file /file_%d", i), arbitraryInfo)
+		sut.pass(fmt.Sprintf("file /file_%d", i), arbitraryInfo, "")
 	}
 
 	assert.Equal(t, 9, sut.deleted)
 }
 
+func TestResults_passQuota(t *testing.T) {
+	sut := &Results{}
+	arbitraryInfo, _ := os.Stat(".")
+
+	// when
+	sut.passQuota("file /file", arbitraryInfo, "")
+
+	assert.Equal(t, 1, sut.deleted)
+	assert.Equal(t, 1, sut.deletedByQuota)
+}
+
 func TestResults_skip(t *testing.T) {
 	sut := &Results{}
 
@@ -45,6 +56,16 @@ func TestResults_skip(t *testing.T) {
 	assert.Equal(t, 9, sut.skipped)
 }
 
+func TestResults_skipInUse(t *testing.T) {
+	sut := &Results{}
+
+	// when
+	sut.skipInUse("file /file")
+
+	assert.Equal(t, 1, sut.inUse)
+	assert.Equal(t, 0, sut.skipped)
+}
+
 func TestResults_PrintStats(t *testing.T) {
 	t.Run("should print stats nicely", func(t *testing.T) {
 		realStdout := os.Stdout
@@ -65,6 +86,111 @@ func TestResults_PrintStats(t *testing.T) {
 		actual := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
 		assert.Equal(t, "[tempdel] deleted: 20 (24 MB), skipped: 8, failed: 1\n", actual)
 	})
+	t.Run("should label stats as hypothetical in dry-run mode", func(t *testing.T) {
+		realStdout := os.Stdout
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		sut := &Results{
+			deleted:       20,
+			deletedSizeKB: 24_890,
+			failed:        1,
+			skipped:       8,
+			dryRun:        true,
+		}
+
+		// when
+		sut.PrintStats()
+
+		// then
+		actual := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Equal(t, "[tempdel] dry-run: would delete: 20 (24 MB), skipped: 8, failed: 1\n", actual)
+	})
+	t.Run("should mark the statistics of an interrupted run as incomplete", func(t *testing.T) {
+		realStdout := os.Stdout
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		sut := &Results{deleted: 3, interrupted: true}
+
+		// when
+		sut.PrintStats()
+
+		// then
+		actual := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Equal(t, "[tempdel] deleted: 3 (0 MB), skipped: 0, failed: 0\n"+
+			"[tempdel] the deletion run was interrupted, so the statistics are incomplete\n", actual)
+	})
+	t.Run("should print files that changed during the run", func(t *testing.T) {
+		realStdout := os.Stdout
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		sut := &Results{deleted: 3, skipped: 2, changed: 1}
+
+		// when
+		sut.PrintStats()
+
+		// then
+		actual := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Equal(t, "[tempdel] deleted: 3 (0 MB), skipped: 2, changed during run: 1, failed: 0\n", actual)
+	})
+	t.Run("should print freed sizes by age and by size budget", func(t *testing.T) {
+		realStdout := os.Stdout
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		sut := &Results{
+			deleted:              20,
+			deletedSizeKB:        24_890,
+			deletedByQuota:       2,
+			deletedByQuotaSizeKB: 4_096,
+			failed:               1,
+			skipped:              8,
+			quotaEnabled:         true,
+		}
+
+		// when
+		sut.PrintStats()
+
+		// then
+		actual := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Equal(t, "[tempdel] deleted: 20 (24 MB, by age: 20 MB, by size budget: 4 MB), skipped: 8, failed: 1\n", actual)
+	})
+	t.Run("should print files in use", func(t *testing.T) {
+		realStdout := os.Stdout
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		sut := &Results{deleted: 20, deletedSizeKB: 24_890, skipped: 8, inUse: 3, openFileCheck: true}
+
+		// when
+		sut.PrintStats()
+
+		// then
+		actual := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Equal(t, "[tempdel] deleted: 20 (24 MB), skipped: 8, in use: 3, failed: 0\n", actual)
+	})
+	t.Run("should print free disk space before and after", func(t *testing.T) {
+		realStdout := os.Stdout
+		defer restoreOriginalStdout(realStdout)
+		fakeReaderPipe, fakeWriterPipe := routeStdoutToReplacement()
+
+		sut := &Results{
+			deleted:         20,
+			deletedSizeKB:   24_890,
+			diskSpaceBefore: &DiskSpace{FreeBytes: 100 << 20, TotalBytes: 1000 << 20},
+			diskSpaceAfter:  &DiskSpace{FreeBytes: 125 << 20, TotalBytes: 1000 << 20},
+		}
+
+		// when
+		sut.PrintStats()
+
+		// then
+		actual := captureOutput(fakeReaderPipe, fakeWriterPipe, realStdout)
+		assert.Equal(t, "[tempdel] deleted: 20 (24 MB), skipped: 0, failed: 0\n"+
+			"[tempdel] free disk space: before: 100 MB (10.0%), after: 125 MB (12.5%)\n", actual)
+	})
 }
 
 func TestResults(t *testing.T) {
@@ -83,17 +209,18 @@ func TestResults(t *testing.T) {
 		info2 := fileInfo(t, file2)
 		info3 := fileInfo(t, file3)
 
-		sut := Results{}
+		sut := &Results{}
 
 		// when
-		sut.pass(file1, info1)
-		sut.pass(file2, info2)
-		sut.pass(file3, info3)
+		sut.pass(file1, info1, "")
+		sut.pass(file2, info2, "")
+		sut.pass(file3, info3, "")
 
 		// then
-		expected := Results{
+		expected := &Results{
 			deleted:       3,
 			deletedSizeKB: 2049,
+			deletedBytes:  2_099_409,
 			failed:        0,
 			skipped:       0,
 		}
@@ -112,17 +239,18 @@ func TestResults(t *testing.T) {
 		info2 := fileInfo(t, file2)
 		info3 := fileInfo(t, file3)
 
-		sut := Results{}
+		sut := &Results{}
 
 		// when
-		sut.pass(file1, info1)
-		sut.pass(file2, info2)
-		sut.pass(file3, info3)
+		sut.pass(file1, info1, "")
+		sut.pass(file2, info2, "")
+		sut.pass(file3, info3, "")
 
 		// then
-		expected := Results{
+		expected := &Results{
 			deleted:       3,
 			deletedSizeKB: 12497,
+			deletedBytes:  12_796_928,
 			failed:        0,
 			skipped:       0,
 		}
@@ -0,0 +1,95 @@
+package deletion
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// TimeSource selects the file timestamp that determines the age of a file.
+type TimeSource string
+
+const (
+	// TimeSourceModification uses the time of the last content modification (mtime).
+	TimeSourceModification TimeSource = "mtime"
+	// TimeSourceAccess uses the time of the last access (atime).
+	TimeSourceAccess TimeSource = "atime"
+	// TimeSourceChange uses the time of the last inode change (ctime), f. e. when a file was copied or renamed.
+	TimeSourceChange TimeSource = "ctime"
+	// TimeSourceBirth uses the creation time (btime) where the filesystem supports it and falls back to mtime otherwise.
+	TimeSourceBirth TimeSource = "btime"
+	// TimeSourceNewest uses the newest of all available timestamps.
+	TimeSourceNewest TimeSource = "newest"
+)
+
+// TimeSources contains all supported time sources.
+var TimeSources = []TimeSource{TimeSourceModification, TimeSourceAccess, TimeSourceChange, TimeSourceBirth, TimeSourceNewest}
+
+// ParseTimeSource parses the name of a time source. An empty name selects mtime.
+func ParseTimeSource(name string) (TimeSource, error) {
+	trimmed := TimeSource(strings.ToLower(strings.TrimSpace(name)))
+	if trimmed == "" {
+		return TimeSourceModification, nil
+	}
+
+	for _, source := range TimeSources {
+		if trimmed == source {
+			return source, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid time source %q: expected one of %v", name, TimeSources)
+}
+
+// fileTimestamps contains all timestamps of a file. Timestamps which are not supported stay zero.
+type fileTimestamps struct {
+	modification time.Time
+	access       time.Time
+	change       time.Time
+	birth        time.Time
+}
+
+// fileTime returns the timestamp of the given file that is selected by the time source.
+func fileTime(source TimeSource, path string, info os.FileInfo) time.Time {
+	if source == TimeSourceModification || source == "" {
+		return info.ModTime()
+	}
+
+	timestamps := readTimestamps(path, info, source == TimeSourceBirth || source == TimeSourceNewest)
+
+	var selected time.Time
+	switch source {
+	case TimeSourceAccess:
+		selected = timestamps.access
+	case TimeSourceChange:
+		selected = timestamps.change
+	case TimeSourceBirth:
+		selected = timestamps.birth
+	case TimeSourceNewest:
+		selected = timestamps.modification
+		for _, other := range []time.Time{timestamps.access, timestamps.change, timestamps.birth} {
+			if other.After(selected) {
+				selected = other
+			}
+		}
+	}
+
+	if selected.IsZero() {
+		log.Debugf("time source %s is not available for %s, falling back to mtime", source, path)
+		return info.ModTime()
+	}
+
+	return selected
+}
+
+// directoryTime returns the newest of the modification and the change time of a directory. Both change whenever an
+// entry is created, renamed or deleted inside the directory.
+func directoryTime(path string, info os.FileInfo) time.Time {
+	timestamps := readTimestamps(path, info, false)
+	if timestamps.change.After(timestamps.modification) {
+		return timestamps.change
+	}
+
+	return timestamps.modification
+}
@@ -0,0 +1,88 @@
+package deletion
+
+import (
+	"bufio"
+	errors2 "github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const mountInfoFile = "/proc/self/mountinfo"
+
+func readTimestamps(path string, info os.FileInfo, withBirth bool) fileTimestamps {
+	timestamps := fileTimestamps{modification: info.ModTime()}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		timestamps.access = time.Unix(stat.Atim.Unix())
+		timestamps.change = time.Unix(stat.Ctim.Unix())
+	}
+
+	if withBirth {
+		statx := unix.Statx_t{}
+		err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &statx)
+		if err == nil && statx.Mask&unix.STATX_BTIME != 0 {
+			timestamps.birth = time.Unix(statx.Btime.Sec, int64(statx.Btime.Nsec))
+		}
+	}
+
+	return timestamps
+}
+
+// MountedWithNoAtime returns true if the filesystem containing the given directory is mounted with the noatime option.
+// On such filesystems the access time is not updated and cannot be used to determine the file age.
+func MountedWithNoAtime(directory string) (bool, error) {
+	return mountedWithNoAtime(mountInfoFile, directory)
+}
+
+func mountedWithNoAtime(mountInfo string, directory string) (bool, error) {
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return false, errors2.Wrapf(err, "could not resolve directory %q", directory)
+	}
+	if resolved, err := filepath.EvalSymlinks(absDirectory); err == nil {
+		absDirectory = resolved
+	}
+
+	file, err := os.Open(mountInfo)
+	if err != nil {
+		return false, errors2.Wrapf(err, "could not read mount information from %q", mountInfo)
+	}
+	defer func() { _ = file.Close() }()
+
+	bestMountPoint := ""
+	bestOptions := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// format: mount-id parent-id major:minor root mount-point mount-options ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+
+		mountPoint := unescapeMountPoint(fields[4])
+		if IsBelow(absDirectory, mountPoint) && len(mountPoint) >= len(bestMountPoint) {
+			bestMountPoint = mountPoint
+			bestOptions = fields[5]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, errors2.Wrapf(err, "could not read mount information from %q", mountInfo)
+	}
+
+	for _, option := range strings.Split(bestOptions, ",") {
+		if option == "noatime" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// unescapeMountPoint replaces octal escapes like \040 for spaces in mount points.
+func unescapeMountPoint(mountPoint string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(mountPoint)
+}
@@ -0,0 +1,54 @@
+package deletion
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testMountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 8:2 / /opt/atlassian rw,noatime shared:2 - ext4 /dev/sda2 rw
+24 23 8:3 / /opt/atlassian/confluence/temp\040dir rw,relatime shared:3 - tmpfs tmpfs rw
+`
+
+func Test_mountedWithNoAtime(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+	defer func() { _ = os.RemoveAll(dir) }()
+	mountInfo := filepath.Join(dir, "mountinfo")
+	require.NoError(t, ioutil.WriteFile(mountInfo, []byte(testMountInfo), 0644))
+
+	tests := []struct {
+		directory string
+		want      bool
+	}{
+		{"/tmp", false},
+		{"/opt/atlassian", true},
+		{"/opt/atlassian/confluence/temp", true},
+		{"/opt/atlassian/confluence/temp dir/upload", false},
+		{"/opt/atlassian-other", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.directory, func(t *testing.T) {
+			actual, err := mountedWithNoAtime(mountInfo, tt.directory)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, actual)
+		})
+	}
+
+	t.Run("should fail on missing mount information", func(t *testing.T) {
+		_, err := mountedWithNoAtime(filepath.Join(dir, "missing"), "/tmp")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not read mount information")
+	})
+}
+
+func TestMountedWithNoAtime(t *testing.T) {
+	_, err := MountedWithNoAtime(os.TempDir())
+
+	require.NoError(t, err)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package deletion
+
+import "os"
+
+func readTimestamps(_ string, info os.FileInfo, _ bool) fileTimestamps {
+	return fileTimestamps{modification: info.ModTime()}
+}
+
+// MountedWithNoAtime returns true if the filesystem containing the given directory is mounted with the noatime option.
+// Mount options can only be determined on Linux, so this always returns false.
+func MountedWithNoAtime(string) (bool, error) {
+	return false, nil
+}
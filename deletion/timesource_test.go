@@ -0,0 +1,64 @@
+package deletion
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseTimeSource(t *testing.T) {
+	t.Run("should default to mtime", func(t *testing.T) {
+		actual, err := ParseTimeSource("")
+
+		require.NoError(t, err)
+		assert.Equal(t, TimeSourceModification, actual)
+	})
+	t.Run("should parse all time sources", func(t *testing.T) {
+		for _, source := range TimeSources {
+			actual, err := ParseTimeSource(" " + string(source) + " ")
+
+			require.NoError(t, err)
+			assert.Equal(t, source, actual)
+		}
+	})
+	t.Run("should fail on unknown time source", func(t *testing.T) {
+		_, err := ParseTimeSource("yesterday")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid time source "yesterday"`)
+	})
+}
+
+func Test_fileTime(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "tempdel-")
+	defer func() { _ = os.RemoveAll(dir) }()
+	accessTime := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+	modificationTime := time.Now().Add(-20 * time.Hour).Truncate(time.Second)
+	file := createFileWithTime(t, dir, "", modificationTime)
+	require.NoError(t, os.Chtimes(file, accessTime, modificationTime))
+	info := fileInfo(t, file)
+
+	t.Run("should return mtime", func(t *testing.T) {
+		assert.True(t, modificationTime.Equal(fileTime(TimeSourceModification, file, info)))
+	})
+	t.Run("should return atime", func(t *testing.T) {
+		assert.True(t, accessTime.Equal(fileTime(TimeSourceAccess, file, info)))
+	})
+	t.Run("should return ctime", func(t *testing.T) {
+		// the inode was changed just now by setting the timestamps
+		assert.WithinDuration(t, time.Now(), fileTime(TimeSourceChange, file, info), time.Minute)
+	})
+	t.Run("should return btime or fall back to mtime", func(t *testing.T) {
+		actual := fileTime(TimeSourceBirth, file, info)
+
+		if !actual.Equal(modificationTime) {
+			assert.WithinDuration(t, time.Now(), actual, time.Minute)
+		}
+	})
+	t.Run("should return the newest timestamp", func(t *testing.T) {
+		assert.WithinDuration(t, time.Now(), fileTime(TimeSourceNewest, file, info), time.Minute)
+	})
+}
@@ -0,0 +1,143 @@
+package deletion
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+var (
+	// isoDurationPattern matches ISO-8601 durations without years and months like P2D, PT90M or P1DT12H.
+	isoDurationPattern = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)W)?(?:(\d+(?:\.\d+)?)D)?` +
+		`(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+	// dayAndWeekPattern matches the day and week units which Go durations do not know.
+	dayAndWeekPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)([dw])`)
+)
+
+var sizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1000,
+	"kb":  1000,
+	"ki":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1000 * 1000,
+	"mb":  1000 * 1000,
+	"mi":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1000 * 1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"gi":  1 << 30,
+	"gib": 1 << 30,
+	"t":   1000 * 1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"ti":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// ParseSize parses a human-readable size like "512", "500MB" or "20GiB" into bytes. Decimal units (KB, MB, GB, TB)
+// are based on 1000, binary units (KiB, MiB, GiB, TiB) are based on 1024. A number without unit counts in bytes.
+func ParseSize(size string) (int64, error) {
+	trimmed := strings.TrimSpace(size)
+	unitStart := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if unitStart == -1 {
+		unitStart = len(trimmed)
+	}
+
+	number, err := strconv.ParseFloat(trimmed[:unitStart], 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q: expected a positive number with an optional unit like MB or GiB", size)
+	}
+
+	multiplier, ok := sizeUnits[strings.ToLower(strings.TrimSpace(trimmed[unitStart:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", size, trimmed[unitStart:])
+	}
+
+	bytes := number * multiplier
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: size is too large", size)
+	}
+
+	return int64(bytes), nil
+}
+
+// ParseDuration parses a duration in one of the following forms:
+//   - a plain integer which is counted in the given legacy unit, f. e. "12" with time.Hour as legacy unit
+//   - a Go duration which may additionally use the units "d" (days) and "w" (weeks), f. e. "90m" or "2d12h"
+//   - an ISO-8601 duration without years and months, f. e. "P2D" or "P1DT12H"
+func ParseDuration(duration string, legacyUnit time.Duration) (time.Duration, error) {
+	trimmed := strings.TrimSpace(duration)
+	if trimmed == "" {
+		return 0, fmt.Errorf("invalid duration %q: must not be empty", duration)
+	}
+
+	if legacyValue, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+		if legacyValue < 0 {
+			return 0, fmt.Errorf("invalid duration %q: must be zero or positive", duration)
+		}
+		return time.Duration(legacyValue) * legacyUnit, nil
+	}
+
+	var parsed time.Duration
+	var err error
+	if strings.HasPrefix(strings.ToUpper(trimmed), "P") {
+		parsed, err = parseISODuration(strings.ToUpper(trimmed))
+	} else {
+		parsed, err = time.ParseDuration(dayAndWeekPattern.ReplaceAllStringFunc(trimmed, daysAndWeeksToHours))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: expected an integer, a duration like 90m or 2d, or an ISO-8601 "+
+			"duration like P1DT12H", duration)
+	}
+	if parsed < 0 {
+		return 0, fmt.Errorf("invalid duration %q: must be zero or positive", duration)
+	}
+
+	return parsed, nil
+}
+
+func daysAndWeeksToHours(value string) string {
+	match := dayAndWeekPattern.FindStringSubmatch(value)
+	number, _ := strconv.ParseFloat(match[1], 64)
+	unit := day
+	if match[2] == "w" {
+		unit = week
+	}
+
+	return strconv.FormatFloat(number*unit.Hours(), 'f', -1, 64) + "h"
+}
+
+func parseISODuration(duration string) (time.Duration, error) {
+	match := isoDurationPattern.FindStringSubmatch(duration)
+	if match == nil || duration == "P" || strings.HasSuffix(duration, "T") {
+		return 0, fmt.Errorf("invalid ISO-8601 duration %q", duration)
+	}
+
+	var result float64
+	for i, unit := range []time.Duration{week, day, time.Hour, time.Minute, time.Second} {
+		if match[i+1] == "" {
+			continue
+		}
+		number, err := strconv.ParseFloat(match[i+1], 64)
+		if err != nil {
+			return 0, err
+		}
+		result += number * float64(unit)
+	}
+
+	if result > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid ISO-8601 duration %q: duration is too large", duration)
+	}
+	return time.Duration(result), nil
+}
@@ -0,0 +1,83 @@
+package deletion
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512B", 512},
+		{"1KB", 1000},
+		{"1KiB", 1024},
+		{"500MB", 500 * 1000 * 1000},
+		{"20GiB", 20 << 30},
+		{"20gi", 20 << 30},
+		{"1.5 GiB", 3 << 29},
+		{"2T", 2 * 1000 * 1000 * 1000 * 1000},
+		{"1TiB", 1 << 40},
+	}
+	for _, tt := range tests {
+		t.Run("should parse "+tt.size, func(t *testing.T) {
+			actual, err := ParseSize(tt.size)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, actual)
+		})
+	}
+
+	for _, invalid := range []string{"", "GiB", "-1GiB", "20XB", "1.2.3MB", "99999999TiB"} {
+		t.Run("should fail on "+invalid, func(t *testing.T) {
+			_, err := ParseSize(invalid)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid size")
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		want     time.Duration
+	}{
+		{"0", 0},
+		{"12", 12 * time.Hour},
+		{"90m", 90 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"2d", 48 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"1w2d", 9 * 24 * time.Hour},
+		{"2d12h30m", 60*time.Hour + 30*time.Minute},
+		{"P2D", 48 * time.Hour},
+		{"P1DT12H", 36 * time.Hour},
+		{"PT90M", 90 * time.Minute},
+		{"PT0.5S", 500 * time.Millisecond},
+		{"P1W", 7 * 24 * time.Hour},
+		{"p1dt1h", 25 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run("should parse "+tt.duration, func(t *testing.T) {
+			actual, err := ParseDuration(tt.duration, time.Hour)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, actual)
+		})
+	}
+
+	for _, invalid := range []string{"", "-1", "-2h", "soon", "2x", "P", "PT", "P1Y", "P1M", "P1H", "PT1D"} {
+		t.Run("should fail on "+invalid, func(t *testing.T) {
+			_, err := ParseDuration(invalid, time.Hour)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid duration")
+		})
+	}
+}